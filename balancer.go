@@ -3,20 +3,28 @@ package minis
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/deltafund/api-fix/marketdata"
 	"github.com/deltafund/api-fix/order"
 	"github.com/deltafund/api-fix/security"
-	"github.com/deltafund/api-fix/utils"
 	"github.com/deltafund/components-support/broker"
 	"github.com/deltafund/components-support/position"
 	"github.com/deltafund/components-support/settings"
 	"github.com/deltafund/components-support/storage"
 )
 
+// AWAIT_POSITION_TIMEOUT es cuanto se espera la posicion con un fill propio
+// antes de cubrir con la que hay.
+const AWAIT_POSITION_TIMEOUT = 5 * time.Second
+
 type Balancer struct {
-	rwMutex  sync.RWMutex
-	security security.Security
+	rwMutex      sync.RWMutex
+	security     security.Security
+	miniSecurity security.Security
+	//instrumento de la pata de cobertura en curso, std o mini
+	hedgeSecurity security.Security
+	books         map[string]marketdata.Book
 
 	//position     position.Position
 	side    order.Side
@@ -24,78 +32,128 @@ type Balancer struct {
 	broker  broker.Broker
 	logger  *storage.Logger
 
-	activeOrder *order.Order
-	sentOrder   *order.Order
+	slot *OrderSlot
 
 	px               float64
 	qty              float64
 	mktPx            float64
 	combinedPosition position.Position
-	pendingCancel    bool
-	cancelRejected   bool
 	cumQty           float64
 	avgBuyPx         float64
 	avgSellPx        float64
 	settingsManager  *settings.SettingsManager
+	specs            *ContractSpecs
+	hedgePolicy      *HedgePolicy
+	params           *ParamStore
+	clock            Clock
+	escalation       EscalationSchedule
+	escalationStart  time.Time
+	escalationRefPx  float64
+	escalationTimer  Timer
+	//se incrementa al parar la escalada, un timer de otra generacion no hace nada
+	escalationGen  uint64
+	ioc            bool
+	positionSource PositionSource
+	positionResync *PositionResync
+	phases         *PhaseTracker
+	siblingNetQty  float64
+	traderUpdater  TraderUpdater
+	forcedTarget   float64
+	//hubo un fill propio que todavia no llego a la posicion neta: se espera
+	//que las toneladas compradas o vendidas de la posicion lleguen a awaitedTons
+	awaitingPosition bool
+	awaitedSide      order.Side
+	awaitedTons      float64
+	fillBaseTons     float64
+	awaitTimer       Timer
+	awaitGen         uint64
 	//Switchs
+	forced     bool
+	unbalanced bool
+	connected  bool
 	enabledAll bool
 	enabled    bool
 }
 
 func NewBalancer(securityFuture security.Security,
-	miniSecurity security.Security, account string, broker broker.Broker) *Balancer {
+	miniSecurity security.Security, account string, broker broker.Broker, specs *ContractSpecs, hedgePolicy *HedgePolicy, params *ParamStore) *Balancer {
+
+	if specs == nil {
+		specs = NewContractSpecs()
+	}
+	if hedgePolicy == nil {
+		hedgePolicy = NewDefaultHedgePolicy()
+	}
+	if params == nil {
+		params, _ = NewParamStore("")
+	}
 
 	loggerName := "balancer"
 	//if side == order.Side_SELL {
 	//	loggerName = "balancer-sell"
 	//}
 
-	return &Balancer{
-		logger:    storage.NewLogger(loggerName),
-		security:  securityFuture,
-		side:      order.Side_BUY,
-		account:   account,
-		broker:    broker,
-		px:        0.0,
-		qty:       0.0,
-		mktPx:     0.0,
-		cumQty:    0.0,
-		avgBuyPx:  0.0,
-		avgSellPx: 0.0,
+	b := &Balancer{
+		logger:        storage.NewLogger(loggerName),
+		security:      securityFuture,
+		miniSecurity:  miniSecurity,
+		hedgeSecurity: securityFuture,
+		books:         make(map[string]marketdata.Book),
+		side:          order.Side_BUY,
+		account:       account,
+		broker:        broker,
+		px:            0.0,
+		qty:           0.0,
+		mktPx:         0.0,
+		cumQty:        0.0,
+		avgBuyPx:      0.0,
+		avgSellPx:     0.0,
 		//newMiniHistoricalPos: 0.0,
 		//newStdHistoricalPos:  0.0,
-		pendingCancel:  false,
-		cancelRejected: false,
-		enabledAll:     true, //en produccion inicializar en false
-		enabled:        true, //en produccion inicializar en false
+		specs:       specs,
+		hedgePolicy: hedgePolicy,
+		params:      params,
+		clock:       NewSystemClock(),
+		ioc:         false,
+		unbalanced:  false,
+		connected:   true,
+		phases:      NewPhaseTracker(),
+		enabledAll:  true, //en produccion inicializar en false
+		enabled:     true, //en produccion inicializar en false
 	}
+	b.slot = NewOrderSlot(broker, b.logger, b, b.clock)
+	return b
 }
 
 func (b *Balancer) OnOrderPlaced(orderPlaced order.OrderPlaced) {
 	//b.logger.Printf("OnOrderPlaced: %+v", orderPlaced)
 
 	b.rwMutex.Lock()
-	if b.invalidEvent(orderPlaced.OrderEvent) {
+	if !b.slot.OnPlaced(orderPlaced.OrderEvent) {
 		b.rwMutex.Unlock()
 		return
 	}
-
-	b.sentOrder = nil
-	b.activeOrder = &orderPlaced.Order
 	b.rebalance()
 	b.rwMutex.Unlock()
 }
 
 func (b *Balancer) OnOrderPlaceRejected(orderPlaceRejected order.OrderPlaceRejected) {
 	b.logger.Printf("OnOrderPlaceRejected: %+v", orderPlaceRejected)
+	alert := ""
 	b.rwMutex.Lock()
-	if b.invalidEvent(orderPlaceRejected.OrderEvent) {
-		b.rwMutex.Unlock()
-		return
+	if b.slot.OnPlaceRejected(orderPlaceRejected.OrderEvent) && b.slot.Rejected() {
+		alert = fmt.Sprintf("%v balancer: %d hedge orders rejected in a row, hedging stopped until %v",
+			b.security.Symbol, MAX_PLACE_REJECTS, CMD_RESET_STATE)
 	}
-	b.pendingCancel = true
-	b.rebalance()
+	traderUpdater := b.traderUpdater
 	b.rwMutex.Unlock()
+
+	if alert != "" {
+		b.logger.Printf("%s", alert)
+		if traderUpdater != nil {
+			traderUpdater.SendToast(alert)
+		}
+	}
 }
 
 func (b *Balancer) BeforeOrderPlacement(beforeOrderPlacement order.BeforeOrderPlacement) {
@@ -105,20 +163,22 @@ func (b *Balancer) BeforeOrderPlacement(beforeOrderPlacement order.BeforeOrderPl
 func (b *Balancer) OnOrderReplaced(orderReplaced order.OrderReplaced) {
 	b.logger.Printf("OnOrderReplaced: %+v", orderReplaced)
 	b.rwMutex.Lock()
-	b.sentOrder = nil
-	b.activeOrder = orderReplaced.NewOrder
+	if !b.slot.OnReplaced(orderReplaced.OrderEvent, orderReplaced.NewOrder) {
+		b.rwMutex.Unlock()
+		return
+	}
 	b.rebalance()
 	b.rwMutex.Unlock()
 }
 func (b *Balancer) OnOrderReplaceRejected(orderReplaceRejected order.OrderReplaceRejected) {
 	b.logger.Printf("OnOrderReplaceRejected: %+v", orderReplaceRejected)
 	b.rwMutex.Lock()
-	if b.invalidEvent(orderReplaceRejected.OrderEvent) {
+	if !b.slot.OnReplaceRejected(orderReplaceRejected.OrderEvent) {
 		b.rwMutex.Unlock()
 		return
 	}
-	b.pendingCancel = true
-	b.rebalance()
+	//si el exchange no acepta el replace se cancela y se manda una orden nueva
+	b.removeOrder()
 	b.rwMutex.Unlock()
 }
 func (b *Balancer) BeforeOrderReplacement(beforeOrderReplacement order.BeforeOrderReplacement) {
@@ -127,162 +187,270 @@ func (b *Balancer) BeforeOrderReplacement(beforeOrderReplacement order.BeforeOrd
 func (b *Balancer) OnOrderCancelled(orderCancelled order.OrderCancelled) {
 	b.logger.Printf("OnOrderCancelled: %+v", orderCancelled)
 	b.rwMutex.Lock()
-	if b.invalidEvent(orderCancelled.OrderEvent) {
+	if !b.slot.OnCancelled(orderCancelled.OrderEvent) {
 		b.rwMutex.Unlock()
 		return
 	}
-	b.sentOrder = nil
-	b.activeOrder = nil
+	b.calculateQty()
+	b.rebalance()
 	b.rwMutex.Unlock()
 }
 
 func (b *Balancer) OnOrderCancelRejected(orderCancelRejected order.OrderCancelRejected) {
 	b.logger.Printf("OnOrderCancelRejected: %+v", orderCancelRejected)
 	b.rwMutex.Lock()
-	if b.invalidEvent(orderCancelRejected.OrderEvent) {
-		b.rwMutex.Unlock()
-		return
-	}
-	b.pendingCancel = true
-	b.cancelRejected = true
-	b.rebalance()
+	b.slot.OnCancelRejected(orderCancelRejected.OrderEvent)
 	b.rwMutex.Unlock()
 }
 func (b *Balancer) BeforeOrderCancellation(beforeOrderCancellation order.BeforeOrderCancellation) {
 }
 
 func (b *Balancer) placeOrder() {
+	//los fills de esta orden se suman a lo que la posicion ya tiene operado
+	b.fillBaseTons = sideTons(b.combinedPosition, b.side)
 	request := order.PlaceOrderRequest{
-		OrderId:  utils.UUID(),
 		Account:  b.account,
-		Security: b.security,
+		Security: b.hedgeSecurity,
 		Qty:      b.qty,
 		Px:       b.px,
 		Side:     b.side,
 		Type:     order.Type_LIMIT,
 		Validity: order.Validity_DAY,
 	}
-	b.px = 0.0
-	newOrder, err := b.broker.PlaceOrder(request, b)
-	if err != nil {
-		b.logger.Printf("Cannot place new order: %+v. Error: %v", request, err)
-		return
+	if b.ioc {
+		request.Validity = order.Validity_IOC
 	}
-
-	b.sentOrder = newOrder
+	b.slot.Place(request)
 }
 
+// replaceOrder modifica la orden activa para que le queden b.qty contratos
+// pendientes al precio b.px. Si el broker no acepta el replace se cancela.
 func (b *Balancer) replaceOrder() {
-	request := order.ReplaceOrderRequest{
-		Order: *b.activeOrder,
-		Qty:   b.qty,
-		Px:    b.px,
-	}
-
-	err := b.broker.ReplaceOrder(request)
-	if err != nil {
-		b.logger.Printf("Cannot replace order %+v with request: %+v. Error: %s", b.activeOrder, request, err)
-		return
+	if !b.slot.Replace(b.px, b.qty) {
+		b.removeOrder()
 	}
-
-	b.sentOrder = b.activeOrder
-	b.sentOrder.Px = b.px
-	b.sentOrder.Qty = 1
 }
 
 func (b *Balancer) rebalance() {
+	b.px = b.calculatePx()
 	//b.logger.Printf("Rebalance b.Px=%+v,  qty=%+v", b.px, b.qty)
-	if b.pendingCancel {
-		b.logger.Printf("Rebalance pendingCancel true")
-		//b.removeOrder()
+	activeOrder := b.slot.Active()
+	if !b.connected {
+		b.logger.Printf("Cannot rebalance. Disconnected, waiting for reconnect")
+	} else if !b.phases.Tradable(b.hedgeSecurity.Symbol) {
+		b.logger.Printf("Cannot rebalance. %v is in phase %v", b.hedgeSecurity.Symbol, b.phases.Phase(b.hedgeSecurity.Symbol))
+		b.removeOrder()
 	} else if !b.enabledAll {
 		b.logger.Printf("Cannot rebalance. Robot is disabled for all assets.")
 		b.removeOrder()
-	} else if b.px <= 0.0 || b.qty == 0.0 {
+	} else if b.slot.Pending() {
+		b.logger.Printf("Cannot rebalance. Another action is pending acknowledgement")
+	} else if b.slot.State() == SLOT_CANCEL_REJECTED {
+		b.logger.Printf("Cannot rebalance. Cancel was rejected, waiting for order state")
+	} else if activeOrder != nil && (b.qty <= 0.0 || activeOrder.Side != b.side ||
+		activeOrder.Security.Symbol != b.hedgeSecurity.Symbol) {
+		//la orden activa ya no corresponde a la cobertura necesaria
+		b.logger.Printf("Cancelling active order %+v, qty: %v side: %v", activeOrder, b.qty, b.side)
+		b.removeOrder()
+	} else if b.px <= 0.0 || b.qty <= 0.0 {
 		b.logger.Printf("Cannot rebalance. Px or qty <= 0  px : %+v qty : %+v", b.px, b.qty)
 		//b.removeOrder()
-	} else if b.sentOrder != nil {
-		b.logger.Printf("Cannot rebalance. Another action is pending acknowledgement")
-	} else if b.activeOrder == nil && b.sentOrder == nil {
-		fmt.Println("Rebalance sending order")
-		b.placeOrder()
-	} else if b.activeOrder.Px != b.px || b.activeOrder.Qty != b.qty {
-		b.placeOrder()
-		b.logger.Printf("active order: %+v\n b Px: %+v\n b qty: %v\n", b.activeOrder, b.px, b.qty)
-	} else if b.combinedPosition.NetQty >= 60 || b.combinedPosition.NetQty <= -60 {
+	} else if b.awaitingPosition {
+		//hasta que la posicion incluya el fill la qty calculada cubre de mas
+		b.logger.Printf("Cannot rebalance. Waiting for the position update of the last fill")
+	} else if b.slot.Idle() {
+		if b.slot.Rejected() {
+			b.logger.Printf("Cannot rebalance. Too many rejected orders")
+			return
+		}
+		b.logger.Printf("Rebalance sending order %v %v %v @ %v", b.hedgeSecurity.Symbol, b.side, b.qty, b.px)
 		b.placeOrder()
-	} else {
-		fmt.Printf("%+vcannot Rebalance, security is a mini %+v :", b.security.Symbol, b.security.Harbour)
+	} else if activeOrder.Px != b.px || b.slot.Leaves() != b.qty {
+		b.logger.Printf("active order: %+v\n b Px: %+v\n b qty: %v\n", activeOrder, b.px, b.qty)
+		b.replaceOrder()
 	}
 }
 
-func (b *Balancer) cancelOrder() {
+func (b *Balancer) removeOrder() {
+	b.slot.Cancel()
+}
 
-	request := order.CancelOrderRequest{
-		Order: *b.activeOrder,
+func (b *Balancer) CheckPendingAck(now time.Time, timeout time.Duration) (string, bool) {
+	b.rwMutex.Lock()
+	defer b.rwMutex.Unlock()
+	if !b.connected {
+		return "", false
 	}
-	err := b.broker.CancelOrder(request)
-	if err != nil {
-		b.logger.Printf("Cannot cancel order %+v. Error %v", b.activeOrder, err)
+	//el slot queda bloqueado hasta saber el estado de la orden, no se manda otra cobertura
+	msg, stuck := b.slot.CheckPendingAck(now, timeout)
+	if stuck {
+		msg = b.security.Symbol + " balancer: " + msg
 	}
-	b.sentOrder = b.activeOrder
+	return msg, stuck
 }
 
-func (b *Balancer) invalidEvent(event order.OrderEvent) bool {
-	invalidOrder := (b.activeOrder == nil && b.sentOrder == nil)
-	invalidOrder = invalidOrder ||
-		((b.activeOrder != nil && b.activeOrder.Id != event.Order.Id) && (b.sentOrder != nil && b.sentOrder.Id != event.Order.Id))
-	if invalidOrder {
-		b.logger.Printf("Received order event for an unknown order: %+v", event)
+func (b *Balancer) OnOrderFilled(orderFilled order.OrderFilled) {
+	b.logger.Printf("OnOrderFilled: %+v", orderFilled)
+
+	b.rwMutex.Lock()
+	if b.slot.Owns(orderFilled.Order.Id) {
+		b.slot.OnFilled(orderFilled.OrderEvent)
+		b.awaitPosition(orderFilled.OrderEvent)
 	}
-	return invalidOrder
+	b.calculateQty()
+	b.rebalance()
+	b.rwMutex.Unlock()
 }
 
-func (b *Balancer) removeOrder() {
-	if b.activeOrder != nil && b.security.Symbol != "MIN" {
-		if b.sentOrder != nil {
-			b.pendingCancel = true
-		} else if !b.cancelRejected {
-			b.cancelOrder()
-		}
+func (b *Balancer) OnOrderPartiallyFilled(orderPartiallyFilled order.OrderPartiallyFilled) {
+	b.logger.Printf("\nBALANCER orderPartiallyFilled: %+v", orderPartiallyFilled)
+	b.rwMutex.Lock()
+	if b.slot.Owns(orderPartiallyFilled.Order.Id) {
+		b.slot.OnPartiallyFilled(orderPartiallyFilled.OrderEvent)
+		b.awaitPosition(orderPartiallyFilled.OrderEvent)
 	}
+	b.calculateQty()
+	b.rebalance()
+	b.rwMutex.Unlock()
 }
 
-func (b *Balancer) OnOrderFilled(orderFilled order.OrderFilled) {
-	b.logger.Printf("OnOrderFilled: %+v", orderFilled)
+// awaitPosition frena la cobertura hasta que la posicion incluya el CumQty de
+// la orden. La posicion y el fill llegan por callbacks distintos; si la
+// posicion llego primero no se espera. AWAIT_POSITION_TIMEOUT es el limite.
+func (b *Balancer) awaitPosition(orderEvent order.OrderEvent) {
+	b.awaitedSide = orderEvent.Order.Side
+	b.awaitedTons = b.fillBaseTons + orderEvent.Order.CumQty*b.specs.TonsPerContract(orderEvent.Order.Security)
+	if b.positionReflectsFill() {
+		b.stopAwaiting()
+		return
+	}
 
-	if orderFilled.Order.Security.Harbour == "MIN" {
+	b.stopAwaiting()
+	b.awaitingPosition = true
+	gen := b.awaitGen
+	b.awaitTimer = b.clock.AfterFunc(AWAIT_POSITION_TIMEOUT, func() {
+		b.onAwaitTimeout(gen)
+	})
+}
 
-		b.rwMutex.Lock()
-		b.sentOrder = nil
-		b.activeOrder = nil
-		b.px = orderFilled.Px
-		b.calculateQty()
-		b.rebalance()
-		b.rwMutex.Unlock()
+func (b *Balancer) positionReflectsFill() bool {
+	const epsilon = 1e-9
+	return sideTons(b.combinedPosition, b.awaitedSide) >= b.awaitedTons-epsilon
+}
 
+func (b *Balancer) stopAwaiting() {
+	b.awaitingPosition = false
+	b.awaitGen++
+	if b.awaitTimer != nil {
+		b.awaitTimer.Stop()
+		b.awaitTimer = nil
 	}
 }
 
-func (b *Balancer) OnOrderPartiallyFilled(orderPartiallyFilled order.OrderPartiallyFilled) {
-	//chequeo que instrumento es, si es mini, guardo los precios
-	b.logger.Printf("\nBALANCER orderPartiallyFilled: %+v", orderPartiallyFilled)
-	if orderPartiallyFilled.Order.Security.Harbour == "MIN" {
-		b.rwMutex.Lock()
-		b.px = orderPartiallyFilled.Px
+func (b *Balancer) onAwaitTimeout(gen uint64) {
+	b.rwMutex.Lock()
+	defer b.rwMutex.Unlock()
+	if gen != b.awaitGen || !b.awaitingPosition {
+		return
+	}
+	b.logger.Printf("No position update %v after the last fill, hedging with the current position", AWAIT_POSITION_TIMEOUT)
+	b.awaitTimer = nil
+	b.stopAwaiting()
+	b.calculateQty()
+	b.rebalance()
+}
+
+// sideTons son las toneladas compradas o vendidas en el dia, crecen con cada fill.
+func sideTons(p position.Position, side order.Side) float64 {
+	if side == order.Side_SELL {
+		return p.SellQty
+	}
+	return p.BuyQty
+}
+
+// OnOrderRegistered informa el estado de una orden; saca al slot de un cancel
+// rechazado.
+func (b *Balancer) OnOrderRegistered(orderRegistered order.OrderRegistered) {
+	b.rwMutex.Lock()
+	if b.slot.OnStatus(orderRegistered.OrderEvent) {
 		b.calculateQty()
 		b.rebalance()
-		b.rwMutex.Unlock()
+	}
+	b.rwMutex.Unlock()
+}
+func (b *Balancer) OnTradeCancel(tradeCancel order.TradeCancel) {
+	// la posicion la corrige el TradeBustHandler, que despues avisa por OnSyntheticPositionChange
+	b.logger.Printf("OnTradeCancel: %+v", tradeCancel)
+}
+
+// OnStartFinish llega al terminar de conectar. Si hubo una desconexion se
+// cancelan las ordenes inciertas y se toma la posicion antes de cubrir.
+func (b *Balancer) OnStartFinish(exchange security.Exchange) {
+	b.logger.Printf("%v Balancer OnStartFinish %v", b.security.Symbol, exchange)
+	alert := ""
+	b.rwMutex.Lock()
+	b.slot.Reconcile()
+	if b.positionResync != nil {
+		if err := b.positionResync.Resync(); err != nil {
+			alert = fmt.Sprintf("%v balancer: %v, hedging the last known position", b.security.Symbol, err)
+		}
+	}
+	if b.positionSource != nil {
+		b.combinedPosition = b.positionSource.Position()
+		b.stopAwaiting()
+	}
+	b.connected = true
+	b.stopEscalation()
+	b.calculateQty()
+	b.rebalance()
+	traderUpdater := b.traderUpdater
+	b.rwMutex.Unlock()
 
+	if alert != "" {
+		b.logger.Printf("%s", alert)
+		if traderUpdater != nil {
+			traderUpdater.SendToast(alert)
+		}
 	}
 }
 
-func (b *Balancer) OnOrderRegistered(orderRegistered order.OrderRegistered) {}
-func (b *Balancer) OnTradeCancel(tradeCancel order.TradeCancel)             {}
-func (b *Balancer) OnStartFinish(exchange security.Exchange)                {}
+func (b *Balancer) SetPositionSource(positionSource PositionSource) {
+	b.rwMutex.Lock()
+	b.positionSource = positionSource
+	b.rwMutex.Unlock()
+}
+
+// SetPositionResync hace que al reconectar se vuelvan a leer las posiciones
+// antes de cubrir.
+func (b *Balancer) SetPositionResync(positionResync *PositionResync) {
+	b.rwMutex.Lock()
+	b.positionResync = positionResync
+	b.rwMutex.Unlock()
+}
 func (b *Balancer) OnTradeFromAnotherAccount(tradeFromAnotherAccount order.TradeFromAnotherAccount) {
 }
 
+// SetFirmPosition hace que el balancer cubra la posicion de la firma: la
+// propia mas la de las cuentas hermanas.
+func (b *Balancer) SetFirmPosition(firmPosition *FirmPosition) {
+	//con el lock tomado un cambio que llegue ya suscripto espera a la lectura
+	b.rwMutex.Lock()
+	b.siblingNetQty = firmPosition.SubscribeAndGet(b)
+	b.rwMutex.Unlock()
+}
+
+func (b *Balancer) OnFirmPositionChange(siblingNetQty float64) {
+	b.rwMutex.Lock()
+	b.siblingNetQty = siblingNetQty
+	b.calculateQty()
+	b.rebalance()
+	b.rwMutex.Unlock()
+}
+
+func (b *Balancer) exposure() float64 {
+	return b.combinedPosition.NetQty + b.siblingNetQty
+}
+
 func (b *Balancer) OnSecurityPositionChange(security security.Security, event position.PositionEvent) {
 	b.logger.Printf("\nOnSecurityPositionChange event: %+v security: %+v ", event, security)
 	b.avgBuyPx = event.NewPosition.AvgBuyPx
@@ -290,9 +458,13 @@ func (b *Balancer) OnSecurityPositionChange(security security.Security, event po
 }
 
 func (b *Balancer) OnSyntheticPositionChange(syntheticInstrument string, event position.PositionEvent) {
-	b.logger.Printf("Synthetic Position, newPosition %s: %+v\n", syntheticInstrument, event.NewPosition)
+	b.logger.Printf("Synthetic Position, newPosition %s: %+v total: %+v\n", syntheticInstrument, event.NewPosition, event.NewHistoricalPosition)
 	b.rwMutex.Lock()
-	b.combinedPosition = event.NewPosition
+	//la historica incluye la del dia, se cubre la exposicion total
+	b.combinedPosition = event.NewHistoricalPosition
+	if b.awaitingPosition && b.positionReflectsFill() {
+		b.stopAwaiting()
+	}
 
 	//if strings.Contains(syntheticInstrument, "ROS") {
 	//	b.avgBuyPx = event.NewPosition.AvgBuyPx
@@ -306,28 +478,146 @@ func (b *Balancer) OnSyntheticPositionChange(syntheticInstrument string, event p
 
 func (b *Balancer) calculateQty() {
 	b.qty = 0
-	if b.combinedPosition.NetQty >= 60 {
+	target := b.hedgePolicy.Target()
+	if b.forced {
+		//FORCE_HEDGE o FLATTEN: se cubre hasta forcedTarget sin mirar el trigger
+		target = b.forcedTarget
+		b.unbalanced = true
+	} else {
+		b.unbalanced = b.hedgePolicy.Unbalanced(b.exposure(), b.unbalanced)
+	}
+	if !b.unbalanced {
+		b.stopEscalation()
+		b.logger.Printf("calculateQty %+v\n", b.exposure())
+		return
+	}
+
+	b.side = order.Side_BUY
+	if b.exposure() > 0 {
 		b.side = order.Side_SELL
-		b.qty = 1
+	}
 
-	} else if b.combinedPosition.NetQty <= -60 {
-		b.side = order.Side_BUY
-		b.qty = 1
+	stdSize := b.specs.TonsPerContract(b.security)
+	miniSize := b.specs.TonsPerContract(b.miniSecurity)
+	plan := PlanHedge(b.exposure(), target, stdSize, miniSize,
+		b.expectedCost(b.security, stdSize), b.expectedCost(b.miniSecurity, miniSize), b.hedgePolicy.UseMinis())
+	if b.forced && plan.StdQty == 0 && plan.MiniQty == 0 {
+		b.logger.Printf("Forced hedge to %v done, position: %v", target, b.exposure())
+		b.forced = false
+		b.unbalanced = false
+		b.stopEscalation()
+		return
+	}
+
+	//se opera una pata por vez, primero el std y despues el residuo en minis
+	b.hedgeSecurity = b.security
+	contracts := plan.StdQty
+	if inFlight := b.slot.Order(); inFlight != nil {
+		b.hedgeSecurity = inFlight.Security
+	} else if plan.StdQty == 0 && plan.MiniQty > 0 {
+		b.hedgeSecurity = b.miniSecurity
+	}
+	if b.hedgeSecurity.Symbol == b.miniSecurity.Symbol {
+		contracts = plan.MiniQty
 	}
-	b.logger.Printf("calculateQty %+v\n", b.combinedPosition.NetQty)
 
+	//la orden en curso se modifica a la cantidad necesaria, nunca se suma otra
+	b.qty = contracts
+	b.startEscalation()
+	if maxChildQty := b.hedgePolicy.MaxChildQty(); maxChildQty > 0 && b.qty > maxChildQty {
+		b.qty = maxChildQty
+	}
+	b.logger.Printf("calculateQty %+v plan: %+v %v qty: %v\n", b.exposure(), plan, b.hedgeSecurity.Symbol, b.qty)
+}
+
+// expectedCost es medio spread del book por las toneladas del contrato, o -1
+// si no hay book con ambas puntas o el instrumento no esta en negociacion.
+func (b *Balancer) expectedCost(sec security.Security, contractSize float64) float64 {
+	if !b.phases.Tradable(sec.Symbol) {
+		return -1.0
+	}
+	bidPx, askPx := topOfBook(b.books[sec.Symbol])
+	if bidPx <= 0.0 || askPx <= 0.0 {
+		return -1.0
+	}
+	return (askPx - bidPx) / 2 * contractSize
 }
 
-// func (b *Balancer) OnBookUpdated(bookUpdated marketdata.BookUpdated) {
-// }
+func (b *Balancer) OnBookUpdated(bookUpdated marketdata.BookUpdated) {
+	b.rwMutex.Lock()
+	b.books[bookUpdated.Security.Symbol] = bookUpdated.Book
+	if bookUpdated.Security.Symbol == b.hedgeSecurity.Symbol && b.unbalanced {
+		b.rebalance()
+	}
+	b.rwMutex.Unlock()
+}
 
-func (b *Balancer) OnDisconnect(exchange security.Exchange) {}
+func (b *Balancer) OnDisconnect(exchange security.Exchange) {
+	b.logger.Printf("%v Balancer OnDisconnect %v, stop hedging until reconnect", b.security.Symbol, exchange)
+	b.rwMutex.Lock()
+	b.connected = false
+	b.slot.MarkUncertain()
+	b.stopEscalation()
+	b.rwMutex.Unlock()
+}
 func (b *Balancer) OnSecurityStatus(securityStatus marketdata.SecurityStatus) {
+	symbol := securityStatus.Security.Symbol
+	if symbol != b.security.Symbol && symbol != b.miniSecurity.Symbol {
+		return
+	}
+
+	b.rwMutex.Lock()
+	if b.phases.Update(securityStatus) {
+		b.logger.Printf("%v trading phase %v", symbol, b.phases.Phase(symbol))
+		b.stopEscalation()
+		b.calculateQty()
+		b.rebalance()
+	}
+	b.rwMutex.Unlock()
 }
 
 /// settings callbacks
 
-func (b *Balancer) OnBotSettingChange(botSetting settings.BotSetting) {}
+func (b *Balancer) OnBotSettingChange(botSetting settings.BotSetting) {
+	b.logger.Printf("%v Balancer OnBotSettingChange %+v\n", b.security.Symbol, botSetting)
+	if err := ValidateBotSetting(botSetting); err != nil {
+		b.logger.Printf("Rejected bot setting %+v: %v", botSetting, err)
+		return
+	}
+
+	b.rwMutex.Lock()
+	applied, err := b.hedgePolicy.ApplyBotSetting(botSetting)
+	if !applied {
+		applied, err = b.escalation.ApplyBotSetting(botSetting)
+		if applied {
+			b.stopEscalation()
+			if err == nil && !b.escalation.Enabled() {
+				b.logger.Printf("Hedge escalation disabled, needs step and max slippage: %+v", b.escalation)
+			}
+		}
+	}
+	if err != nil {
+		b.logger.Printf("Invalid bot setting %+v: %v", botSetting, err)
+	} else if applied {
+		b.calculateQty()
+		b.rebalance()
+	}
+	settingsManager := b.settingsManager
+	b.rwMutex.Unlock()
+
+	if applied && err == nil && settingsManager != nil {
+		if isHedgeLevel(botSetting.Key) {
+			//puede haber aplicado tambien un nivel que estaba pendiente
+			trigger, target, hysteresis := b.hedgePolicy.Get()
+			settingsManager.ChangeBotSetting(HEDGE_TRIGGER, trigger)
+			settingsManager.ChangeBotSetting(HEDGE_TARGET, target)
+			settingsManager.ChangeBotSetting(HEDGE_HYSTERESIS, hysteresis)
+		} else {
+			settingsManager.ChangeBotSetting(botSetting.Key, botSetting.Value)
+		}
+	}
+}
+
 func (b *Balancer) OnBotEnabledChange(botEnabled settings.Enabled) {
 	b.logger.Printf("%v Balancer OnBotEnabledChange %+v\n", b.security.Symbol, botEnabled)
 	b.rwMutex.Lock()
@@ -345,13 +635,80 @@ func (b *Balancer) OnBotEnabledChange(botEnabled settings.Enabled) {
 	b.rwMutex.Unlock()
 }
 
-func (b *Balancer) OnCommand(command settings.FrontCommand) {}
+func (b *Balancer) OnCommand(command settings.FrontCommand) {
+	if !commandFor(command, b.security.Symbol, b.miniSecurity.Symbol) {
+		return
+	}
+	b.logger.Printf("%v Balancer OnCommand %+v\n", b.security.Symbol, command)
+
+	b.rwMutex.Lock()
+	msg := ""
+	switch command.Command {
+	case CMD_FORCE_HEDGE:
+		b.forced = true
+		b.forcedTarget = b.hedgePolicy.Target()
+		b.calculateQty()
+		b.rebalance()
+		msg = fmt.Sprintf("hedging %v to %v", b.exposure(), b.forcedTarget)
+	case CMD_FLATTEN:
+		b.forced = true
+		b.forcedTarget = 0.0
+		b.calculateQty()
+		b.rebalance()
+		msg = fmt.Sprintf("flattening %v", b.exposure())
+	case CMD_RESET_STATE:
+		b.slot.Reset()
+		b.forced = false
+		b.stopAwaiting()
+		b.stopEscalation()
+		b.calculateQty()
+		b.rebalance()
+		msg = "state reset"
+	case CMD_DUMP_STATUS:
+		msg = b.status()
+	case CMD_CANCEL_QUOTES, CMD_PAUSE_BID, CMD_PAUSE_ASK, CMD_RESUME_BID, CMD_RESUME_ASK, CMD_DUMP_PNL:
+		//los atienden los market makers y el PnLEngine
+	default:
+		b.logger.Printf("%v Command not recognized: %+v", b.security.Symbol, command)
+	}
+	b.rwMutex.Unlock()
+
+	if msg != "" {
+		b.ack(command, msg)
+	}
+}
+
+func (b *Balancer) status() string {
+	return fmt.Sprintf("enabledAll: %v connected: %v unbalanced: %v forced: %v position: %v siblingNetQty: %v hedge: %v %v px: %v qty: %v slot: %v order: %+v",
+		b.enabledAll, b.connected, b.unbalanced, b.forced, b.combinedPosition.NetQty, b.siblingNetQty, b.hedgeSecurity.Symbol, b.side, b.px, b.qty, b.slot.State(), b.slot.Order())
+}
+
+func (b *Balancer) ack(command settings.FrontCommand, msg string) {
+	ack := fmt.Sprintf("%v balancer %v: %v", b.security.Symbol, command.Command, msg)
+	b.logger.Printf("%s", ack)
+	if b.traderUpdater != nil {
+		b.traderUpdater.SendToast(ack)
+	}
+}
+
+func (b *Balancer) SetSettingsManager(settingsManager *settings.SettingsManager) {
+	b.rwMutex.Lock()
+	b.settingsManager = settingsManager
+	b.rwMutex.Unlock()
+}
+
+func (b *Balancer) SetTraderUpdater(traderUpdater TraderUpdater) {
+	b.rwMutex.Lock()
+	b.traderUpdater = traderUpdater
+	b.rwMutex.Unlock()
+}
+
 func (b *Balancer) OnAssetSettingChange(assetSetting settings.AssetSetting) {
 	if assetSetting.Asset != b.security.Symbol {
 		b.logger.Printf("Ignoring asset event %+v: %+v", &b.security.Symbol, assetSetting)
 		return
 	}
-	rebalance := false
+	rebalance, recalculate := false, false
 	switch assetSetting.Key {
 	case settings.SWITCH_ASSET_BID:
 		b.logger.Printf("%+v Switch asset bid: %+v", &b.security.Symbol, assetSetting)
@@ -365,51 +722,46 @@ func (b *Balancer) OnAssetSettingChange(assetSetting settings.AssetSetting) {
 			b.switchState(assetSetting)
 		}
 
-	case settings.CHANGE_VOL_BID:
-		b.logger.Printf("%+v Change vol bid: %+v", &b.security.Symbol, assetSetting)
-		if b.side == order.Side_BUY {
-			if assetSetting.Value >= 0.0 {
-				rebalance = true
-				b.rwMutex.Unlock()
-			}
-		}
+	case settings.CHANGE_VOL_BID, settings.CHANGE_VOL_ASK:
+		//el balancer no cotiza con spread, el vol lo usan los market makers
+		b.logger.Printf("%+v Ignoring change vol on balancer: %+v", &b.security.Symbol, assetSetting)
 
-	case settings.CHANGE_VOL_ASK:
-		b.logger.Printf("%+v Change vol ask: %+v", &b.security.Symbol, assetSetting)
-		if b.side == order.Side_SELL {
-			if assetSetting.Value >= 0.0 {
-				rebalance = true
-			}
+	case HEDGE_PX_MODE:
+		b.logger.Printf("%+v Change hedge px mode: %+v", &b.security.Symbol, assetSetting)
+		if err := b.params.SetHedgePxMode(b.security.Symbol, int(assetSetting.Value)); err != nil {
+			b.logger.Printf("%+v Invalid hedge px mode: %v", b.security.Symbol, err)
+		} else {
+			rebalance = true
 		}
 
-	case settings.CHANGE_QTY_BID:
-		b.logger.Printf("%+v Change qty bid: %+v", &b.security.Symbol, assetSetting)
-		if b.side == order.Side_BUY {
-			if assetSetting.Value >= 0.0 {
-				b.rwMutex.Lock()
-				b.qty = assetSetting.Value
-				rebalance = true
-				b.rwMutex.Unlock()
-			}
+	case HEDGE_PROTECTION_TICKS:
+		b.logger.Printf("%+v Change hedge protection ticks: %+v", &b.security.Symbol, assetSetting)
+		if err := b.params.SetProtectionTicks(b.security.Symbol, assetSetting.Value); err != nil {
+			b.logger.Printf("%+v Invalid hedge protection ticks: %v", b.security.Symbol, err)
+		} else {
+			rebalance = true
 		}
 
-	case settings.CHANGE_QTY_ASK:
-		b.logger.Printf("%+v Change qty ask: %+v", &b.security.Symbol, assetSetting)
-		if b.side == order.Side_SELL {
-			if assetSetting.Value >= 0.0 {
-				b.rwMutex.Lock()
-				b.qty = assetSetting.Value
-				rebalance = true
-				b.rwMutex.Unlock()
-			}
+	case settings.CHANGE_QTY_BID, settings.CHANGE_QTY_ASK:
+		//el tamano de la cobertura sale de calculateQty, el qty del front solo
+		//acota cada orden hija
+		b.logger.Printf("%+v Change hedge max child qty: %+v", &b.security.Symbol, assetSetting)
+		if err := b.hedgePolicy.SetMaxChildQty(assetSetting.Value); err != nil {
+			b.logger.Printf("%+v Invalid hedge max child qty: %v", b.security.Symbol, err)
+		} else {
+			recalculate = true
 		}
 	default:
 		b.logger.Printf("%+v Asset setting key not recognized: %+v", &b.security.Symbol, assetSetting)
 	}
 
-	if rebalance {
+	if recalculate {
+		b.rwMutex.Lock()
+		b.calculateQty()
+		b.rebalance()
+		b.rwMutex.Unlock()
+	} else if rebalance {
 		b.rwMutex.Lock()
-		//b.px = b.calculatePx()
 		b.rebalance()
 		b.rwMutex.Unlock()
 	} else {
@@ -423,6 +775,9 @@ func (b *Balancer) deactivate(notify string) {
 		switch notify {
 		case "asset":
 			b.enabled = false
+			if b.settingsManager == nil {
+				break
+			}
 			if b.side == order.Side_SELL {
 				b.settingsManager.ChangeAssetState(settings.SWITCH_ASSET_ASK, 0, b.security.Symbol)
 			} else {
@@ -431,9 +786,14 @@ func (b *Balancer) deactivate(notify string) {
 
 		case "global":
 			b.enabledAll = false
-			b.settingsManager.ChangeRobotState(0)
+			if b.settingsManager != nil {
+				b.settingsManager.ChangeRobotState(0)
+			}
 		default:
-			b.logger.Printf(notify)
+			b.logger.Printf("%s", notify)
+			if b.traderUpdater != nil {
+				b.traderUpdater.SendToast(notify)
+			}
 		}
 	}
 	b.removeOrder()
@@ -457,7 +817,104 @@ func (b *Balancer) switchState(assetSetting settings.AssetSetting) {
 	}
 }
 
-//func (b *Balancer) calculatePx() float64 {
-//return b.mktPx
+// calculatePx toma el book del instrumento de cobertura. PASSIVE se para en
+// la punta propia, MID en el mid y CROSS cruza hasta ProtectionTicks mas alla
+// de la punta contraria.
+func (b *Balancer) calculatePx() float64 {
+	bidPx, askPx := topOfBook(b.books[b.hedgeSecurity.Symbol])
+	mode, protectionTicks := b.params.HedgePricing(b.security.Symbol)
+
+	b.ioc = false
+	if b.escalation.Enabled() && !b.escalationStart.IsZero() {
+		if b.escalationRefPx <= 0.0 {
+			b.escalationRefPx = bidPx
+			if b.side == order.Side_SELL {
+				b.escalationRefPx = askPx
+			}
+		}
+		elapsed := b.clock.Now().Sub(b.escalationStart)
+		px, ioc := b.escalation.Px(b.side, bidPx, askPx, b.escalationRefPx, b.specs.TickSize(b.hedgeSecurity), elapsed)
+		b.mktPx = px
+		b.ioc = ioc
+		return b.specs.RoundPx(b.hedgeSecurity, px, b.side)
+	}
+
+	px := 0.0
+	switch mode {
+	case HEDGE_PX_PASSIVE:
+		px = bidPx
+		if b.side == order.Side_SELL {
+			px = askPx
+		}
+
+	case HEDGE_PX_MID:
+		if bidPx > 0.0 && askPx > 0.0 {
+			px = (bidPx + askPx) / 2
+		}
+
+	case HEDGE_PX_CROSS:
+		if bidPx <= 0.0 || askPx <= 0.0 {
+			break
+		}
+		protection := protectionTicks * b.specs.TickSize(b.hedgeSecurity)
+		if b.side == order.Side_BUY {
+			px = askPx + protection
+		} else {
+			px = bidPx - protection
+		}
+	}
+
+	b.mktPx = px
+	return b.specs.RoundPx(b.hedgeSecurity, px, b.side)
+}
+
+func (b *Balancer) SetClock(clock Clock) {
+	b.rwMutex.Lock()
+	b.clock = clock
+	b.slot.SetClock(clock)
+	b.rwMutex.Unlock()
+}
 
-//}
+func (b *Balancer) startEscalation() {
+	if !b.escalation.Enabled() || !b.escalationStart.IsZero() {
+		return
+	}
+	b.escalationStart = b.clock.Now()
+	b.escalationRefPx = 0.0
+	b.scheduleEscalation()
+}
+
+func (b *Balancer) stopEscalation() {
+	if b.escalationTimer != nil {
+		b.escalationTimer.Stop()
+		b.escalationTimer = nil
+	}
+	b.escalationStart = time.Time{}
+	b.escalationRefPx = 0.0
+	b.escalationGen++
+}
+
+func (b *Balancer) scheduleEscalation() {
+	elapsed := b.clock.Now().Sub(b.escalationStart)
+	gen := b.escalationGen
+	b.escalationTimer = b.clock.AfterFunc(b.escalation.NextStep(elapsed), func() {
+		b.onEscalationStep(gen)
+	})
+}
+
+func (b *Balancer) onEscalationStep(gen uint64) {
+	b.rwMutex.Lock()
+	defer b.rwMutex.Unlock()
+	if gen != b.escalationGen {
+		//el timer se disparo despues de parar la escalada
+		return
+	}
+	b.escalationTimer = nil
+	if !b.escalation.Enabled() {
+		b.stopEscalation()
+	} else if !b.escalationStart.IsZero() {
+		b.calculateQty()
+		b.rebalance()
+		b.scheduleEscalation()
+	}
+}
@@ -0,0 +1,247 @@
+package minis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/components-support/position"
+	"github.com/deltafund/components-support/settings"
+)
+
+// soldEvent es una posicion de netQty con sellQty toneladas vendidas en el dia.
+func soldEvent(netQty float64, sellQty float64) position.PositionEvent {
+	p := position.Position{NetQty: netQty, BuyQty: netQty + sellQty, SellQty: sellQty}
+	return position.PositionEvent{NewPosition: p, NewHistoricalPosition: p}
+}
+
+func TestBalancerWaitsForPositionAfterFill(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnSyntheticPositionChange("net", soldEvent(230, 0))
+	if len(fb.places) != 1 || fb.places[0].Side != order.Side_SELL || fb.places[0].Qty != 2 {
+		t.Fatalf("places = %+v, want one sell of 2", fb.places)
+	}
+	placed := fb.lastOrder()
+	b.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: placed}})
+
+	//el fill llega antes que la posicion: la orden no vuelve a 2 contratos
+	filled := placed
+	filled.CumQty = 1
+	b.OnOrderPartiallyFilled(order.OrderPartiallyFilled{OrderEvent: order.OrderEvent{Order: filled}})
+	if len(fb.replaces) != 0 || len(fb.places) != 1 || !b.awaitingPosition {
+		t.Fatalf("resized before the position update: places %+v replaces %+v", fb.places, fb.replaces)
+	}
+
+	//una posicion sin el fill no termina la espera
+	b.OnSyntheticPositionChange("net", soldEvent(240, 0))
+	if !b.awaitingPosition || len(fb.replaces) != 0 {
+		t.Fatalf("stopped waiting on a position without the fill")
+	}
+
+	//con la posicion actualizada falta justo lo que queda de la orden
+	b.OnSyntheticPositionChange("net", soldEvent(130, 100))
+	if b.awaitingPosition || len(fb.replaces) != 0 || len(fb.places) != 1 || len(fb.cancels) != 0 {
+		t.Fatalf("order changed after the position update: places %+v replaces %+v cancels %+v", fb.places, fb.replaces, fb.cancels)
+	}
+	if clock.Pending() != 0 {
+		t.Fatalf("await timer still pending after the position update")
+	}
+}
+
+func TestBalancerPositionBeforeFill(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnSyntheticPositionChange("net", soldEvent(230, 0))
+	placed := fb.lastOrder()
+	b.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: placed}})
+
+	//la posicion ya incluye el fill cuando llega el execution report
+	b.OnSyntheticPositionChange("net", soldEvent(130, 100))
+	filled := placed
+	filled.CumQty = 1
+	b.OnOrderPartiallyFilled(order.OrderPartiallyFilled{OrderEvent: order.OrderEvent{Order: filled}})
+	if b.awaitingPosition || clock.Pending() != 0 {
+		t.Fatalf("waiting for a position that already has the fill")
+	}
+}
+
+func TestBalancerStopsWaitingAfterTimeout(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnSyntheticPositionChange("net", soldEvent(230, 0))
+	placed := fb.lastOrder()
+	b.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: placed}})
+	filled := placed
+	filled.CumQty = 1
+	b.OnOrderPartiallyFilled(order.OrderPartiallyFilled{OrderEvent: order.OrderEvent{Order: filled}})
+
+	clock.Advance(AWAIT_POSITION_TIMEOUT)
+	if b.awaitingPosition {
+		t.Fatalf("still waiting for the position after %v", AWAIT_POSITION_TIMEOUT)
+	}
+}
+
+func TestBalancerAlertsAfterPlaceRejects(t *testing.T) {
+	b, fb, _ := newTestBalancer(t)
+	tu := &fakeTraderUpdater{}
+	b.SetTraderUpdater(tu)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnSyntheticPositionChange("net", netQtyEvent(230))
+	for i := 0; i < MAX_PLACE_REJECTS; i++ {
+		b.OnOrderPlaceRejected(order.OrderPlaceRejected{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+		b.OnSyntheticPositionChange("net", netQtyEvent(230))
+	}
+	if len(fb.places) != MAX_PLACE_REJECTS {
+		t.Fatalf("places = %d, want %d", len(fb.places), MAX_PLACE_REJECTS)
+	}
+	if len(tu.toasts) != 1 {
+		t.Fatalf("toasts = %q, want one alert", tu.toasts)
+	}
+
+	b.OnCommand(settings.FrontCommand{Command: CMD_RESET_STATE, Asset: testStd.Symbol})
+	if len(fb.places) != MAX_PLACE_REJECTS+1 {
+		t.Fatalf("no new hedge after %v, places = %d", CMD_RESET_STATE, len(fb.places))
+	}
+}
+
+func TestBalancerFlatten(t *testing.T) {
+	tests := []struct {
+		name   string
+		netQty float64
+		places int
+		side   order.Side
+		qty    float64
+	}{
+		{"less than half a contract", 30, 0, 0, 0},
+		{"more than half a contract", 60, 1, order.Side_SELL, 1},
+		{"short", -160, 1, order.Side_BUY, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, fb, _ := newTestBalancer(t)
+			b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+			b.OnSyntheticPositionChange("net", netQtyEvent(tt.netQty))
+
+			b.OnCommand(settings.FrontCommand{Command: CMD_FLATTEN, Asset: testStd.Symbol})
+			if len(fb.places) != tt.places {
+				t.Fatalf("places = %+v, want %d", fb.places, tt.places)
+			}
+			if tt.places == 0 {
+				if b.forced {
+					t.Fatalf("forced hedge still on with nothing to trade")
+				}
+				return
+			}
+			if fb.places[0].Side != tt.side || fb.places[0].Qty != tt.qty {
+				t.Fatalf("place = %+v, want %v %v", fb.places[0], tt.side, tt.qty)
+			}
+		})
+	}
+}
+
+func TestHedgeContracts(t *testing.T) {
+	tests := []struct {
+		netQty    float64
+		target    float64
+		contracts float64
+	}{
+		{30, 40, 0},
+		{30, 0, 0},
+		{50, 0, 0},
+		{60, 0, 1},
+		{230, 40, 2},
+		{250, 40, 2},
+		{-260, 40, 3},
+		{45, 40, 0},
+	}
+	for _, tt := range tests {
+		if contracts := HedgeContracts(tt.netQty, tt.target, 100); contracts != tt.contracts {
+			t.Errorf("HedgeContracts(%v, %v) = %v, want %v", tt.netQty, tt.target, contracts, tt.contracts)
+		}
+	}
+}
+
+func TestBalancerChangeQtyOnlyCapsChildOrders(t *testing.T) {
+	b, fb, _ := newTestBalancer(t)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnAssetSettingChange(settings.AssetSetting{Asset: testStd.Symbol, Key: settings.CHANGE_QTY_BID, Value: 7})
+	if len(fb.places) != 0 {
+		t.Fatalf("flat balancer placed %+v", fb.places)
+	}
+	b.OnAssetSettingChange(settings.AssetSetting{Asset: testStd.Symbol, Key: settings.CHANGE_QTY_ASK, Value: 1})
+	if b.hedgePolicy.MaxChildQty() != 1 {
+		t.Fatalf("max child qty = %v, want 1", b.hedgePolicy.MaxChildQty())
+	}
+
+	b.OnSyntheticPositionChange("net", netQtyEvent(230))
+	if len(fb.places) != 1 || fb.places[0].Qty != 1 {
+		t.Fatalf("places = %+v, want one child of 1", fb.places)
+	}
+}
+
+func TestBalancerRejectedProbeDoesNotHedgeTwice(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+	b.OnSyntheticPositionChange("net", netQtyEvent(230))
+	placed := fb.lastOrder()
+
+	clock.Advance(time.Minute)
+	b.CheckPendingAck(clock.Now(), 30*time.Second)
+	b.OnOrderCancelRejected(order.OrderCancelRejected{OrderEvent: order.OrderEvent{Order: placed}})
+	b.OnSyntheticPositionChange("net", netQtyEvent(230))
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(99, 100)})
+	if len(fb.places) != 1 {
+		t.Fatalf("places = %+v, want no second hedge while the first is unresolved", fb.places)
+	}
+}
+
+func TestBalancerResyncsPositionsOnReconnect(t *testing.T) {
+	b, fb, _ := newTestBalancer(t)
+	nfp, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), b.specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetPositionSource(nfp)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	//un fill de 3 std mientras el robot estaba caido
+	b.SetPositionResync(NewPositionResync(func() (map[string]position.Position, error) {
+		return map[string]position.Position{testStd.Symbol: {BuyQty: 3, AvgBuyPx: 100}}, nil
+	}, nfp))
+	b.OnStartFinish(testStd.Exchange)
+	if len(fb.places) != 1 || fb.places[0].Side != order.Side_SELL {
+		t.Fatalf("places = %+v, want a sell hedge of the missed fill", fb.places)
+	}
+}
+
+func TestHedgePolicyLevelsInAnyOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings []settings.BotSetting
+		trigger  float64
+		target   float64
+	}{
+		{"trigger first", []settings.BotSetting{{Key: HEDGE_TRIGGER, Value: 30}, {Key: HEDGE_TARGET, Value: 20}}, 30, 20},
+		{"target first", []settings.BotSetting{{Key: HEDGE_TARGET, Value: 20}, {Key: HEDGE_TRIGGER, Value: 30}}, 30, 20},
+		{"raise both", []settings.BotSetting{{Key: HEDGE_TARGET, Value: 80}, {Key: HEDGE_TRIGGER, Value: 100}}, 100, 80},
+		{"invalid pair kept pending", []settings.BotSetting{{Key: HEDGE_TRIGGER, Value: 30}}, 60, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hp := NewDefaultHedgePolicy()
+			for _, botSetting := range tt.settings {
+				hp.ApplyBotSetting(botSetting)
+			}
+			if trigger, target, _ := hp.Get(); trigger != tt.trigger || target != tt.target {
+				t.Fatalf("levels = %v, %v, want %v, %v", trigger, target, tt.trigger, tt.target)
+			}
+		})
+	}
+}
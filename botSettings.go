@@ -0,0 +1,85 @@
+package minis
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/deltafund/components-support/settings"
+)
+
+const (
+	QUOTE_QTY = "QUOTE_QTY"
+
+	SPREAD_ONE_SIDED    = "SPREAD_ONE_SIDED"
+	SPREAD_TIER_1_WIDTH = "SPREAD_TIER_1_WIDTH"
+	SPREAD_TIER_1       = "SPREAD_TIER_1"
+	SPREAD_TIER_2_WIDTH = "SPREAD_TIER_2_WIDTH"
+	SPREAD_TIER_2       = "SPREAD_TIER_2"
+	SPREAD_TIER_3_WIDTH = "SPREAD_TIER_3_WIDTH"
+	SPREAD_TIER_3       = "SPREAD_TIER_3"
+
+	MAX_TONS float64 = 100000.0
+)
+
+// BotSettingSpec es el rango valido de un setting global del robot. Los
+// booleanos van como entero entre 0 y 1.
+type BotSettingSpec struct {
+	Min     float64
+	Max     float64
+	Integer bool
+}
+
+var botSettingSpecs = map[settings.Key]BotSettingSpec{
+	HEDGE_TRIGGER:    {Min: 0.0, Max: MAX_TONS},
+	HEDGE_TARGET:     {Min: 0.0, Max: MAX_TONS},
+	HEDGE_HYSTERESIS: {Min: 0.0, Max: MAX_TONS},
+	HEDGE_MAX_CHILD:  {Min: 0.0, Max: MAX_TONS, Integer: true},
+	HEDGE_USE_MINIS:  {Min: 0.0, Max: 1.0, Integer: true},
+
+	ESCALATION_PASSIVE_SECS:       {Min: 0.0, Max: 3600.0},
+	ESCALATION_STEP_SECS:          {Min: 0.0, Max: 3600.0},
+	ESCALATION_MAX_SLIPPAGE_TICKS: {Min: 0.0, Max: 1000.0},
+
+	QUOTE_QTY: {Min: DEFAULT_MIN_QTY, Max: DEFAULT_MAX_QTY, Integer: true},
+
+	SKEW_TICKS_PER_10_TONS: {Min: 0.0, Max: 100.0},
+	SKEW_MAX_TICKS:         {Min: 0.0, Max: 1000.0},
+
+	SPREAD_ONE_SIDED:    {Min: 0.0, Max: MAX_SIDE_VOL},
+	SPREAD_TIER_1_WIDTH: {Min: 0.0, Max: MAX_TONS},
+	SPREAD_TIER_1:       {Min: 0.0, Max: MAX_SIDE_VOL},
+	SPREAD_TIER_2_WIDTH: {Min: 0.0, Max: MAX_TONS},
+	SPREAD_TIER_2:       {Min: 0.0, Max: MAX_SIDE_VOL},
+	SPREAD_TIER_3_WIDTH: {Min: 0.0, Max: MAX_TONS},
+	SPREAD_TIER_3:       {Min: 0.0, Max: MAX_SIDE_VOL},
+}
+
+func isSpreadSetting(key settings.Key) bool {
+	switch key {
+	case SPREAD_ONE_SIDED, SPREAD_TIER_1_WIDTH, SPREAD_TIER_1, SPREAD_TIER_2_WIDTH, SPREAD_TIER_2, SPREAD_TIER_3_WIDTH, SPREAD_TIER_3:
+		return true
+	}
+	return false
+}
+
+// ValidateBotSetting chequea la clave y el rango antes de tocar el estado de
+// ningun componente.
+func ValidateBotSetting(botSetting settings.BotSetting) error {
+	spec, ok := botSettingSpecs[botSetting.Key]
+	if !ok {
+		return fmt.Errorf("unknown bot setting %v", botSetting.Key)
+	}
+	if math.IsNaN(botSetting.Value) || botSetting.Value < spec.Min || botSetting.Value > spec.Max {
+		return fmt.Errorf("bot setting %v out of range [%v, %v]: %v", botSetting.Key, spec.Min, spec.Max, botSetting.Value)
+	}
+	if spec.Integer && botSetting.Value != math.Trunc(botSetting.Value) {
+		return fmt.Errorf("bot setting %v must be an integer: %v", botSetting.Key, botSetting.Value)
+	}
+	return nil
+}
+
+// BotSettingApplier es un componente configurable por settings globales.
+// Devuelve false si la clave no le corresponde.
+type BotSettingApplier interface {
+	ApplyBotSetting(botSetting settings.BotSetting) (bool, error)
+}
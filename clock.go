@@ -0,0 +1,27 @@
+package minis
+
+import "time"
+
+// Clock permite reemplazar el reloj del sistema en los tests.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func NewSystemClock() Clock {
+	return systemClock{}
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,38 @@
+package minis
+
+import (
+	"github.com/deltafund/components-support/settings"
+)
+
+// Comandos que manda la mesa por FrontCommand.Command. FrontCommand.Asset
+// indica el mini (o el std para el balancer); vacio aplica a todas las
+// instancias.
+const (
+	CMD_CANCEL_QUOTES = "CANCEL_QUOTES"
+	CMD_PAUSE_BID     = "PAUSE_BID"
+	CMD_PAUSE_ASK     = "PAUSE_ASK"
+	CMD_RESUME_BID    = "RESUME_BID"
+	CMD_RESUME_ASK    = "RESUME_ASK"
+	CMD_FORCE_HEDGE   = "FORCE_HEDGE"
+	CMD_FLATTEN       = "FLATTEN"
+	CMD_RESET_STATE   = "RESET_STATE"
+	CMD_DUMP_STATUS   = "DUMP_STATUS"
+	CMD_DUMP_PNL      = "DUMP_PNL"
+)
+
+// TraderUpdater manda mensajes al front de la mesa.
+type TraderUpdater interface {
+	SendToast(message string)
+}
+
+func commandFor(command settings.FrontCommand, assets ...string) bool {
+	if command.Asset == "" {
+		return true
+	}
+	for _, asset := range assets {
+		if command.Asset == asset {
+			return true
+		}
+	}
+	return false
+}
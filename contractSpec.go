@@ -0,0 +1,179 @@
+package minis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+)
+
+const (
+	DEFAULT_STD_MULTIPLIER  float64 = 100.0
+	DEFAULT_MINI_MULTIPLIER float64 = 10.0
+	DEFAULT_TICK_SIZE       float64 = 0.1
+)
+
+// ContractSpec describe un instrumento: tick, toneladas por contrato y la
+// banda de precios del dia (0 si no hay limite).
+type ContractSpec struct {
+	TickSize   float64
+	Multiplier float64
+	LowLimit   float64
+	HighLimit  float64
+}
+
+// RoundPx redondea los bids hacia abajo y los asks hacia arriba a un tick
+// valido y los acota a la banda de precios.
+func (spec ContractSpec) RoundPx(px float64, side order.Side) float64 {
+	if px <= 0.0 {
+		return px
+	}
+
+	if spec.HighLimit > 0.0 && px > spec.HighLimit {
+		px = spec.HighLimit
+	}
+	if spec.LowLimit > 0.0 && px < spec.LowLimit {
+		px = spec.LowLimit
+	}
+
+	if spec.TickSize > 0.0 {
+		const epsilon = 1e-9
+		ticks := px / spec.TickSize
+		if side == order.Side_BUY {
+			ticks = math.Floor(ticks + epsilon)
+		} else {
+			ticks = math.Ceil(ticks - epsilon)
+		}
+		px = math.Round(ticks*spec.TickSize/epsilon) * epsilon
+
+		if spec.HighLimit > 0.0 && px > spec.HighLimit {
+			px -= spec.TickSize
+		}
+		if spec.LowLimit > 0.0 && px < spec.LowLimit {
+			px += spec.TickSize
+		}
+	}
+	return px
+}
+
+type ContractSpecs struct {
+	rwMutex sync.RWMutex
+	specs   map[string]ContractSpec
+}
+
+func NewContractSpecs() *ContractSpecs {
+	return &ContractSpecs{
+		specs: make(map[string]ContractSpec),
+	}
+}
+
+// LoadContractSpecs lee un JSON con specs por ticker o por producto, el ticker
+// sin el vencimiento, p.ej.:
+//
+//	{"SOJ.ROS": {"TickSize": 0.1, "Multiplier": 100},
+//	 "SOJ.MIN": {"TickSize": 0.1, "Multiplier": 10}}
+//
+// El spec de un ticker pisa el de su producto.
+func LoadContractSpecs(path string) (*ContractSpecs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := NewContractSpecs()
+	if err := json.Unmarshal(data, &cs.specs); err != nil {
+		return nil, fmt.Errorf("cannot parse contract specs file %s: %v", path, err)
+	}
+	for symbol, spec := range cs.specs {
+		if spec.Multiplier <= 0.0 || spec.TickSize < 0.0 {
+			return nil, fmt.Errorf("invalid contract spec for %s in %s: %+v", symbol, path, spec)
+		}
+	}
+	return cs, nil
+}
+
+func (cs *ContractSpecs) Register(sec security.Security, spec ContractSpec) {
+	cs.rwMutex.Lock()
+	cs.specs[sec.Symbol] = spec
+	cs.rwMutex.Unlock()
+}
+
+func (cs *ContractSpecs) Get(sec security.Security) (ContractSpec, bool) {
+	cs.rwMutex.RLock()
+	defer cs.rwMutex.RUnlock()
+	return cs.get(sec.Symbol)
+}
+
+// ContractSize son las toneladas por contrato, o defaultSize si el
+// instrumento no esta registrado.
+func (cs *ContractSpecs) ContractSize(sec security.Security, defaultSize float64) float64 {
+	spec, ok := cs.Get(sec)
+	if !ok || spec.Multiplier <= 0.0 {
+		return defaultSize
+	}
+	return spec.Multiplier
+}
+
+// TonsPerContract son las toneladas por contrato del spec o, si el
+// instrumento no esta registrado, las del mini en MIN y las del std en el
+// resto de los mercados.
+func (cs *ContractSpecs) TonsPerContract(sec security.Security) float64 {
+	return cs.ContractSize(sec, DefaultContractSize(sec))
+}
+
+// TickSize es el tick del spec o DEFAULT_TICK_SIZE si no esta registrado.
+func (cs *ContractSpecs) TickSize(sec security.Security) float64 {
+	spec, ok := cs.Get(sec)
+	if !ok || spec.TickSize <= 0.0 {
+		return DEFAULT_TICK_SIZE
+	}
+	return spec.TickSize
+}
+
+func DefaultContractSize(sec security.Security) float64 {
+	harbour := sec.Harbour
+	if harbour == "" {
+		if ticker, err := ParseTicker(sec.Symbol); err == nil {
+			harbour = ticker.Harbour
+		}
+	}
+	if harbour == "MIN" {
+		return DEFAULT_MINI_MULTIPLIER
+	}
+	return DEFAULT_STD_MULTIPLIER
+}
+
+func (cs *ContractSpecs) SetPriceLimits(sec security.Security, lowLimit float64, highLimit float64) {
+	cs.rwMutex.Lock()
+	spec, _ := cs.get(sec.Symbol)
+	spec.LowLimit = lowLimit
+	spec.HighLimit = highLimit
+	cs.specs[sec.Symbol] = spec
+	cs.rwMutex.Unlock()
+}
+
+// RoundPx deja el precio sin cambios si el instrumento no esta registrado.
+func (cs *ContractSpecs) RoundPx(sec security.Security, px float64, side order.Side) float64 {
+	spec, ok := cs.Get(sec)
+	if !ok {
+		return px
+	}
+	return spec.RoundPx(px, side)
+}
+
+func (cs *ContractSpecs) get(symbol string) (ContractSpec, bool) {
+	if spec, ok := cs.specs[symbol]; ok {
+		return spec, true
+	}
+	product, _, found := strings.Cut(symbol, "/")
+	if !found {
+		return ContractSpec{}, false
+	}
+	spec, ok := cs.specs[product]
+	return spec, ok
+}
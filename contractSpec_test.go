@@ -0,0 +1,26 @@
+package minis
+
+import (
+	"testing"
+
+	"github.com/deltafund/api-fix/security"
+)
+
+func TestTonsPerContract(t *testing.T) {
+	specs := NewContractSpecs()
+	specs.Register(security.Security{Symbol: "TRI.ROS/JUL24"}, ContractSpec{TickSize: 0.1, Multiplier: 30})
+	tests := []struct {
+		sec  security.Security
+		tons float64
+	}{
+		{testStd, DEFAULT_STD_MULTIPLIER},
+		{testMini, DEFAULT_MINI_MULTIPLIER},
+		{security.Security{Symbol: "MAI.MIN/JUL24"}, DEFAULT_MINI_MULTIPLIER},
+		{security.Security{Symbol: "TRI.ROS/JUL24"}, 30},
+	}
+	for _, tt := range tests {
+		if tons := specs.TonsPerContract(tt.sec); tons != tt.tons {
+			t.Errorf("TonsPerContract(%v) = %v, want %v", tt.sec.Symbol, tons, tt.tons)
+		}
+	}
+}
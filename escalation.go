@@ -0,0 +1,105 @@
+package minis
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/components-support/settings"
+)
+
+const (
+	ESCALATION_PASSIVE_SECS       = "ESCALATION_PASSIVE_SECS"
+	ESCALATION_STEP_SECS          = "ESCALATION_STEP_SECS"
+	ESCALATION_MAX_SLIPPAGE_TICKS = "ESCALATION_MAX_SLIPPAGE_TICKS"
+)
+
+// EscalationSchedule define como se vuelve agresiva una cobertura: queda
+// pasiva en la punta propia PassiveFor, despues avanza un tick cada StepEvery
+// hacia la punta contraria y al alcanzarla cruza con IOC. Nunca se aleja mas
+// de MaxSlippageTicks del precio de referencia tomado al empezar la cobertura.
+// Con StepEvery o MaxSlippageTicks en 0 la escalada esta deshabilitada: sin
+// slippage la cobertura no puede alejarse del precio de referencia.
+type EscalationSchedule struct {
+	PassiveFor       time.Duration
+	StepEvery        time.Duration
+	MaxSlippageTicks float64
+}
+
+func NewEscalationSchedule(passiveFor time.Duration, stepEvery time.Duration, maxSlippageTicks float64) (EscalationSchedule, error) {
+	if passiveFor < 0 || stepEvery < 0 {
+		return EscalationSchedule{}, fmt.Errorf("escalation times must not be negative: %v, %v", passiveFor, stepEvery)
+	}
+	if stepEvery > 0 && !(maxSlippageTicks > 0.0) {
+		return EscalationSchedule{}, fmt.Errorf("escalation every %v needs a positive max slippage: %v", stepEvery, maxSlippageTicks)
+	}
+	return EscalationSchedule{
+		PassiveFor:       passiveFor,
+		StepEvery:        stepEvery,
+		MaxSlippageTicks: maxSlippageTicks,
+	}, nil
+}
+
+func (es EscalationSchedule) Enabled() bool {
+	return es.StepEvery > 0 && es.MaxSlippageTicks > 0.0
+}
+
+// Px devuelve el precio para el tiempo transcurrido y si la orden debe ir IOC.
+func (es EscalationSchedule) Px(side order.Side, bidPx float64, askPx float64, refPx float64, tickSize float64, elapsed time.Duration) (float64, bool) {
+	ownPx, oppositePx := bidPx, askPx
+	direction := 1.0
+	if side == order.Side_SELL {
+		ownPx, oppositePx = askPx, bidPx
+		direction = -1.0
+	}
+	if ownPx <= 0.0 {
+		return 0.0, false
+	}
+	if elapsed < es.PassiveFor {
+		return ownPx, false
+	}
+
+	steps := math.Floor(float64(elapsed-es.PassiveFor)/float64(es.StepEvery)) + 1
+	px := ownPx + direction*steps*tickSize
+	limitPx := refPx + direction*es.MaxSlippageTicks*tickSize
+
+	cross := false
+	if oppositePx > 0.0 && direction*(px-oppositePx) >= 0.0 {
+		px = oppositePx
+		cross = direction*(oppositePx-limitPx) <= 0.0
+	}
+	if direction*(px-limitPx) > 0.0 {
+		px = limitPx
+	}
+	return px, cross
+}
+
+// NextStep es el tiempo hasta el proximo cambio de precio.
+func (es EscalationSchedule) NextStep(elapsed time.Duration) time.Duration {
+	if elapsed < es.PassiveFor {
+		return es.PassiveFor - elapsed
+	}
+	return es.StepEvery - (elapsed-es.PassiveFor)%es.StepEvery
+}
+
+func (es *EscalationSchedule) ApplyBotSetting(botSetting settings.BotSetting) (bool, error) {
+	switch botSetting.Key {
+	case ESCALATION_PASSIVE_SECS, ESCALATION_STEP_SECS, ESCALATION_MAX_SLIPPAGE_TICKS:
+	default:
+		return false, nil
+	}
+	if botSetting.Value < 0.0 {
+		return true, fmt.Errorf("escalation setting must not be negative: %+v", botSetting)
+	}
+
+	switch botSetting.Key {
+	case ESCALATION_PASSIVE_SECS:
+		es.PassiveFor = time.Duration(botSetting.Value * float64(time.Second))
+	case ESCALATION_STEP_SECS:
+		es.StepEvery = time.Duration(botSetting.Value * float64(time.Second))
+	case ESCALATION_MAX_SLIPPAGE_TICKS:
+		es.MaxSlippageTicks = botSetting.Value
+	}
+	return true, nil
+}
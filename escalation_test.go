@@ -0,0 +1,208 @@
+package minis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/position"
+	"github.com/deltafund/components-support/settings"
+)
+
+var (
+	testStd  = security.Security{Symbol: "SOJ.ROS/MAY24", Harbour: "ROS", Exchange: security.Exchange_ROFEX}
+	testMini = security.Security{Symbol: "SOJ.MIN/MAY24", Harbour: "MIN", Exchange: security.Exchange_ROFEX}
+)
+
+func newTestBalancer(t *testing.T) (*Balancer, *fakeBroker, *fakeClock) {
+	t.Helper()
+	specs := NewContractSpecs()
+	specs.Register(testStd, ContractSpec{TickSize: 0.5, Multiplier: 100})
+	specs.Register(testMini, ContractSpec{TickSize: 0.5, Multiplier: 10})
+
+	fb := &fakeBroker{}
+	clock := newFakeClock()
+	b := NewBalancer(testStd, testMini, "account", fb, specs, nil, nil)
+	b.SetClock(clock)
+	return b, fb, clock
+}
+
+func testBook(bidPx float64, askPx float64) marketdata.Book {
+	return marketdata.Book{
+		Bids: []marketdata.Level{{Px: bidPx, Qty: 10}},
+		Asks: []marketdata.Level{{Px: askPx, Qty: 10}},
+	}
+}
+
+func netQtyEvent(netQty float64) position.PositionEvent {
+	p := position.Position{NetQty: netQty}
+	return position.PositionEvent{NewPosition: p, NewHistoricalPosition: p}
+}
+
+func TestEscalationScheduleApplyBotSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting settings.BotSetting
+		applied bool
+		wantErr bool
+		want    EscalationSchedule
+	}{
+		{"passive", settings.BotSetting{Key: ESCALATION_PASSIVE_SECS, Value: 10}, true, false, EscalationSchedule{PassiveFor: 10 * time.Second}},
+		{"step", settings.BotSetting{Key: ESCALATION_STEP_SECS, Value: 2.5}, true, false, EscalationSchedule{StepEvery: 2500 * time.Millisecond}},
+		{"slippage", settings.BotSetting{Key: ESCALATION_MAX_SLIPPAGE_TICKS, Value: 4}, true, false, EscalationSchedule{MaxSlippageTicks: 4}},
+		{"negative", settings.BotSetting{Key: ESCALATION_STEP_SECS, Value: -1}, true, true, EscalationSchedule{}},
+		{"other key", settings.BotSetting{Key: HEDGE_TARGET, Value: 40}, false, false, EscalationSchedule{}},
+		{"other key negative", settings.BotSetting{Key: SPREAD_TIER_1, Value: -1}, false, false, EscalationSchedule{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := EscalationSchedule{}
+			applied, err := es.ApplyBotSetting(tt.setting)
+			if applied != tt.applied || (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyBotSetting(%+v) = %v, %v", tt.setting, applied, err)
+			}
+			if es != tt.want {
+				t.Fatalf("schedule = %+v, want %+v", es, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscalationSchedulePx(t *testing.T) {
+	es := EscalationSchedule{PassiveFor: 10 * time.Second, StepEvery: 5 * time.Second, MaxSlippageTicks: 3}
+	tests := []struct {
+		elapsed time.Duration
+		side    order.Side
+		px      float64
+		ioc     bool
+		next    time.Duration
+	}{
+		{0, order.Side_SELL, 101, false, 10 * time.Second},
+		{9 * time.Second, order.Side_SELL, 101, false, time.Second},
+		{10 * time.Second, order.Side_SELL, 100.5, false, 5 * time.Second},
+		{15 * time.Second, order.Side_SELL, 100, true, 5 * time.Second},
+		{40 * time.Second, order.Side_SELL, 100, true, 5 * time.Second},
+		{12 * time.Second, order.Side_BUY, 100.5, false, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		refPx := 101.0
+		if tt.side == order.Side_BUY {
+			refPx = 100.0
+		}
+		px, ioc := es.Px(tt.side, 100, 101, refPx, 0.5, tt.elapsed)
+		if px != tt.px || ioc != tt.ioc {
+			t.Errorf("Px(%v, %v) = %v, %v, want %v, %v", tt.side, tt.elapsed, px, ioc, tt.px, tt.ioc)
+		}
+		if next := es.NextStep(tt.elapsed); next != tt.next {
+			t.Errorf("NextStep(%v) = %v, want %v", tt.elapsed, next, tt.next)
+		}
+	}
+
+	//el limite de slippage corta antes de llegar a la punta contraria
+	es.MaxSlippageTicks = 1
+	if px, ioc := es.Px(order.Side_SELL, 99, 101, 101, 0.5, 30*time.Second); px != 100.5 || ioc {
+		t.Errorf("Px with slippage limit = %v, %v, want 100.5, false", px, ioc)
+	}
+}
+
+func TestBalancerEscalationFollowsClock(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnBotSettingChange(settings.BotSetting{Key: ESCALATION_PASSIVE_SECS, Value: 10})
+	b.OnBotSettingChange(settings.BotSetting{Key: ESCALATION_STEP_SECS, Value: 5})
+	b.OnBotSettingChange(settings.BotSetting{Key: ESCALATION_MAX_SLIPPAGE_TICKS, Value: 3})
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnSyntheticPositionChange("net", netQtyEvent(120))
+	if len(fb.places) != 1 || fb.places[0].Side != order.Side_SELL || fb.places[0].Qty != 1 || fb.places[0].Px != 101 {
+		t.Fatalf("places = %+v, want one passive sell of 1 @ 101", fb.places)
+	}
+	placed := fb.lastOrder()
+	b.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: placed}})
+
+	steps := []struct {
+		advance time.Duration
+		px      float64
+	}{
+		{10 * time.Second, 100.5},
+		{5 * time.Second, 100},
+	}
+	live := placed
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if len(fb.replaces) != i+1 || fb.replaces[i].Px != step.px {
+			t.Fatalf("after %v replaces = %+v, want px %v", step.advance, fb.replaces, step.px)
+		}
+		replaced := live
+		replaced.Px = fb.replaces[i].Px
+		replaced.Qty = fb.replaces[i].Qty
+		b.OnOrderReplaced(order.OrderReplaced{OrderEvent: order.OrderEvent{Order: live}, NewOrder: &replaced})
+		live = replaced
+	}
+
+	//ya esta en la punta contraria y el limite de slippage no deja seguir
+	clock.Advance(10 * time.Second)
+	if len(fb.replaces) != 2 || len(fb.places) != 1 {
+		t.Fatalf("order changed past the opposite touch: places %+v replaces %+v", fb.places, fb.replaces)
+	}
+
+	b.OnSyntheticPositionChange("net", netQtyEvent(20))
+	if len(fb.cancels) != 1 {
+		t.Fatalf("cancels = %+v, want the hedge cancelled once balanced", fb.cancels)
+	}
+	if clock.Pending() != 0 {
+		t.Fatalf("escalation timer still pending after the hedge stopped")
+	}
+}
+
+func TestNewEscalationSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		passive  time.Duration
+		step     time.Duration
+		slippage float64
+		wantErr  bool
+		enabled  bool
+	}{
+		{"enabled", 10 * time.Second, 5 * time.Second, 3, false, true},
+		{"disabled", 0, 0, 0, false, false},
+		{"no slippage", 10 * time.Second, 5 * time.Second, 0, true, false},
+		{"negative step", 0, -time.Second, 3, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, err := NewEscalationSchedule(tt.passive, tt.step, tt.slippage)
+			if (err != nil) != tt.wantErr || es.Enabled() != tt.enabled {
+				t.Fatalf("NewEscalationSchedule = %+v, %v", es, err)
+			}
+		})
+	}
+}
+
+func TestBalancerIgnoresStaleEscalationStep(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnBotSettingChange(settings.BotSetting{Key: ESCALATION_PASSIVE_SECS, Value: 10})
+	b.OnBotSettingChange(settings.BotSetting{Key: ESCALATION_STEP_SECS, Value: 5})
+	b.OnBotSettingChange(settings.BotSetting{Key: ESCALATION_MAX_SLIPPAGE_TICKS, Value: 3})
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+
+	b.OnSyntheticPositionChange("net", netQtyEvent(120))
+	b.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+	staleGen := b.escalationGen
+
+	//se para la escalada y empieza otra cobertura con su propia orden
+	b.OnSyntheticPositionChange("net", netQtyEvent(20))
+	b.OnOrderCancelled(order.OrderCancelled{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+	b.OnSyntheticPositionChange("net", netQtyEvent(120))
+	b.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+	if len(fb.places) != 2 {
+		t.Fatalf("places = %+v, want a second hedge", fb.places)
+	}
+
+	//el timer de la primera escalada no reprecia la orden nueva
+	b.onEscalationStep(staleGen)
+	if len(fb.replaces) != 0 || clock.Pending() != 1 {
+		t.Fatalf("stale escalation step ran: replaces %+v, %d timers", fb.replaces, clock.Pending())
+	}
+}
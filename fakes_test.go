@@ -0,0 +1,147 @@
+package minis
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deltafund/api-fix/order"
+)
+
+// fakeClock solo avanza con Advance y dispara los timers vencidos en orden.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.May, 6, 11, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if !t.stopped && !t.at.After(end) {
+				next = t
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.stopped = true
+		c.now = next.at
+		c.mutex.Unlock()
+		next.f()
+		c.mutex.Lock()
+	}
+	c.now = end
+	c.mutex.Unlock()
+}
+
+// Pending es la cantidad de timers sin disparar ni cancelar.
+func (c *fakeClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pending := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			pending++
+		}
+	}
+	return pending
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+// fakeBroker guarda los pedidos y devuelve ordenes con ids correlativos.
+type fakeBroker struct {
+	places   []order.PlaceOrderRequest
+	replaces []order.ReplaceOrderRequest
+	cancels  []order.CancelOrderRequest
+	orders   []*order.Order
+	err      error
+}
+
+func (fb *fakeBroker) PlaceOrder(request order.PlaceOrderRequest, listener interface{}) (*order.Order, error) {
+	if fb.err != nil {
+		return nil, fb.err
+	}
+	fb.places = append(fb.places, request)
+	newOrder := &order.Order{
+		Id:       fmt.Sprintf("order-%d", len(fb.places)),
+		Account:  request.Account,
+		Security: request.Security,
+		Px:       request.Px,
+		Qty:      request.Qty,
+		Side:     request.Side,
+		Type:     request.Type,
+		Validity: request.Validity,
+	}
+	fb.orders = append(fb.orders, newOrder)
+	return newOrder, nil
+}
+
+func (fb *fakeBroker) ReplaceOrder(request order.ReplaceOrderRequest) error {
+	if fb.err != nil {
+		return fb.err
+	}
+	fb.replaces = append(fb.replaces, request)
+	return nil
+}
+
+func (fb *fakeBroker) CancelOrder(request order.CancelOrderRequest) error {
+	if fb.err != nil {
+		return fb.err
+	}
+	fb.cancels = append(fb.cancels, request)
+	return nil
+}
+
+func (fb *fakeBroker) lastOrder() order.Order {
+	return *fb.orders[len(fb.orders)-1]
+}
+
+type fakeTraderUpdater struct {
+	mutex  sync.Mutex
+	toasts []string
+}
+
+func (tu *fakeTraderUpdater) SendToast(message string) {
+	tu.mutex.Lock()
+	tu.toasts = append(tu.toasts, message)
+	tu.mutex.Unlock()
+}
@@ -0,0 +1,145 @@
+package minis
+
+import (
+	"sync"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/broker"
+	"github.com/deltafund/components-support/storage"
+)
+
+// FirmPositionListener recibe la posicion neta en toneladas de las cuentas
+// hermanas cada vez que cambia.
+type FirmPositionListener interface {
+	OnFirmPositionChange(siblingNetQty float64)
+}
+
+// FirmPosition junta los trades que hacen otras cuentas de la firma en el std
+// y el mini del mismo producto. Solo cuenta las cuentas configuradas; sin
+// cuentas no hace nada. La posicion propia del robot no se incluye, cada
+// componente la suma a la suya.
+type FirmPosition struct {
+	rwMutex      sync.RWMutex
+	logger       *storage.Logger
+	stdSecurity  security.Security
+	miniSecurity security.Security
+	specs        *ContractSpecs
+	accounts     map[string]bool
+	netQty       map[string]float64
+	listeners    []FirmPositionListener
+}
+
+func NewFirmPosition(stdSecurity security.Security, miniSecurity security.Security, specs *ContractSpecs, accounts []string) *FirmPosition {
+	if specs == nil {
+		specs = NewContractSpecs()
+	}
+	fp := &FirmPosition{
+		logger:       storage.NewLogger("firm-position"),
+		stdSecurity:  stdSecurity,
+		miniSecurity: miniSecurity,
+		specs:        specs,
+		accounts:     make(map[string]bool),
+		netQty:       make(map[string]float64),
+	}
+	for _, account := range accounts {
+		fp.accounts[account] = true
+	}
+	return fp
+}
+
+func (fp *FirmPosition) Subscribe(listener FirmPositionListener) {
+	fp.rwMutex.Lock()
+	fp.listeners = append(fp.listeners, listener)
+	fp.rwMutex.Unlock()
+}
+
+// SubscribeAndGet suscribe listener y devuelve la posicion de ese momento, asi
+// no se pierde ningun trade entre la lectura y la suscripcion.
+func (fp *FirmPosition) SubscribeAndGet(listener FirmPositionListener) float64 {
+	fp.rwMutex.Lock()
+	defer fp.rwMutex.Unlock()
+	fp.listeners = append(fp.listeners, listener)
+	return fp.total()
+}
+
+// NetQty es la suma en toneladas de todas las cuentas hermanas.
+func (fp *FirmPosition) NetQty() float64 {
+	fp.rwMutex.RLock()
+	defer fp.rwMutex.RUnlock()
+	return fp.total()
+}
+
+func (fp *FirmPosition) AccountNetQty(account string) float64 {
+	fp.rwMutex.RLock()
+	defer fp.rwMutex.RUnlock()
+	return fp.netQty[account]
+}
+
+func (fp *FirmPosition) OnTradeFromAnotherAccount(tradeFromAnotherAccount order.TradeFromAnotherAccount) {
+	fp.apply(tradeFromAnotherAccount.OrderEvent, 1)
+}
+
+// OnTradeCancel revierte un trade de una cuenta hermana anulado por el mercado.
+func (fp *FirmPosition) OnTradeCancel(tradeCancel order.TradeCancel) {
+	fp.apply(tradeCancel.OrderEvent, -1)
+}
+
+func (fp *FirmPosition) apply(orderEvent order.OrderEvent, sign float64) {
+	fp.rwMutex.Lock()
+	account := orderEvent.Order.Account
+	if !fp.accounts[account] {
+		fp.rwMutex.Unlock()
+		return
+	}
+
+	sizePerContract := 0.0
+	switch orderEvent.Order.Security.Symbol {
+	case fp.stdSecurity.Symbol:
+		sizePerContract = fp.specs.TonsPerContract(fp.stdSecurity)
+	case fp.miniSecurity.Symbol:
+		sizePerContract = fp.specs.TonsPerContract(fp.miniSecurity)
+	default:
+		fp.rwMutex.Unlock()
+		return
+	}
+
+	tons := sign * orderEvent.ExecutionReport.Qty * sizePerContract
+	if orderEvent.ExecutionReport.Side == order.Side_SELL {
+		tons = -tons
+	}
+	fp.netQty[account] += tons
+	total := fp.total()
+	listeners := fp.listeners
+	fp.rwMutex.Unlock()
+
+	fp.logger.Printf("%s %s %v tons, cuentas hermanas: %v", account, orderEvent.Order.Security.Symbol, tons, total)
+	for _, listener := range listeners {
+		listener.OnFirmPositionChange(total)
+	}
+}
+
+func (fp *FirmPosition) total() float64 {
+	total := 0.0
+	for _, netQty := range fp.netQty {
+		total += netQty
+	}
+	return total
+}
+
+// StartFirmSubscriptions activa el modo firma: el balancer y los market makers
+// pasan a mirar la posicion propia mas la de las cuentas hermanas.
+func StartFirmSubscriptions(myBroker broker.DefaultBroker, firmPosition *FirmPosition, mmBuys *MinisMarketMaker, mmSells *MinisMarketMaker, balancers *Balancer) {
+	myBroker.SubscribeExchange(security.Exchange_ROFEX, firmPosition)
+	mmBuys.SetFirmPosition(firmPosition)
+	mmSells.SetFirmPosition(firmPosition)
+	balancers.SetFirmPosition(firmPosition)
+}
+
+// exchange callbacks ///
+
+func (fp *FirmPosition) OnOrderRegistered(orderRegistered order.OrderRegistered)   {}
+func (fp *FirmPosition) OnStartFinish(exchange security.Exchange)                  {}
+func (fp *FirmPosition) OnDisconnect(exchange security.Exchange)                   {}
+func (fp *FirmPosition) OnSecurityStatus(securityStatus marketdata.SecurityStatus) {}
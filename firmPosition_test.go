@@ -0,0 +1,41 @@
+package minis
+
+import (
+	"testing"
+
+	"github.com/deltafund/api-fix/order"
+)
+
+type fakeFirmListener struct {
+	totals []float64
+}
+
+func (fl *fakeFirmListener) OnFirmPositionChange(siblingNetQty float64) {
+	fl.totals = append(fl.totals, siblingNetQty)
+}
+
+func siblingTrade(account string, side order.Side, qty float64) order.OrderEvent {
+	return order.OrderEvent{
+		Order:           order.Order{Account: account, Security: testStd, Side: side},
+		ExecutionReport: order.ExecutionReport{Side: side, Qty: qty, Px: 100},
+	}
+}
+
+func TestFirmPositionReversesBustedTrades(t *testing.T) {
+	fp := NewFirmPosition(testStd, testMini, testSpecs(), []string{"sibling"})
+	fp.OnTradeFromAnotherAccount(order.TradeFromAnotherAccount{OrderEvent: siblingTrade("sibling", order.Side_BUY, 2)})
+
+	listener := &fakeFirmListener{}
+	if netQty := fp.SubscribeAndGet(listener); netQty != 200 {
+		t.Fatalf("SubscribeAndGet = %v, want 200", netQty)
+	}
+
+	fp.OnTradeCancel(order.TradeCancel{OrderEvent: siblingTrade("sibling", order.Side_BUY, 2)})
+	fp.OnTradeCancel(order.TradeCancel{OrderEvent: siblingTrade("other", order.Side_SELL, 1)})
+	if fp.NetQty() != 0 || fp.AccountNetQty("sibling") != 0 {
+		t.Fatalf("net qty after the bust = %v, want 0", fp.NetQty())
+	}
+	if len(listener.totals) != 1 || listener.totals[0] != 0 {
+		t.Fatalf("listener totals = %v, want [0]", listener.totals)
+	}
+}
@@ -0,0 +1,49 @@
+package minis
+
+import "math"
+
+// HedgePlan es la cantidad de contratos std y mini a operar para cubrir una
+// exposicion. El sentido de la cobertura lo define el signo de la posicion.
+type HedgePlan struct {
+	StdQty  float64
+	MiniQty float64
+}
+
+// PlanHedge elige la combinacion de std y minis que deja el menor residuo en
+// toneladas y, a igual residuo, el menor costo esperado. stdCost y miniCost
+// son el costo esperado por contrato; un costo negativo indica que no hay
+// book para ese instrumento y no se usa.
+func PlanHedge(netQty float64, target float64, stdSize float64, miniSize float64, stdCost float64, miniCost float64, useMinis bool) HedgePlan {
+	if !useMinis || miniCost < 0.0 || miniSize <= 0.0 {
+		return HedgePlan{StdQty: HedgeContracts(netQty, target, stdSize)}
+	}
+
+	exposure := math.Abs(netQty)
+	if exposure <= target {
+		return HedgePlan{}
+	}
+
+	const epsilon = 1e-9
+	best := HedgePlan{}
+	bestLeftover := exposure
+	bestCost := 0.0
+	maxStd := math.Ceil(exposure / stdSize)
+	if stdCost < 0.0 {
+		maxStd = 0
+	}
+
+	for stdQty := 0.0; stdQty <= maxStd; stdQty++ {
+		rest := exposure - stdQty*stdSize
+		miniQty := math.Max(math.Round(rest/miniSize), 0)
+		leftover := math.Abs(rest - miniQty*miniSize)
+		cost := stdQty*math.Max(stdCost, 0) + miniQty*miniCost
+
+		if leftover < bestLeftover-epsilon ||
+			(math.Abs(leftover-bestLeftover) <= epsilon && cost < bestCost-epsilon) {
+			best = HedgePlan{StdQty: stdQty, MiniQty: miniQty}
+			bestLeftover = leftover
+			bestCost = cost
+		}
+	}
+	return best
+}
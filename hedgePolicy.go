@@ -0,0 +1,219 @@
+package minis
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/deltafund/components-support/settings"
+)
+
+const (
+	HEDGE_TRIGGER    = "HEDGE_TRIGGER"
+	HEDGE_TARGET     = "HEDGE_TARGET"
+	HEDGE_HYSTERESIS = "HEDGE_HYSTERESIS"
+	HEDGE_MAX_CHILD  = "HEDGE_MAX_CHILD"
+	HEDGE_USE_MINIS  = "HEDGE_USE_MINIS"
+
+	HEDGE_PX_MODE          = "HEDGE_PX_MODE"
+	HEDGE_PROTECTION_TICKS = "HEDGE_PROTECTION_TICKS"
+)
+
+// HedgePolicyListener se entera de los cambios de la politica que aplica el
+// balancer.
+type HedgePolicyListener interface {
+	OnHedgePolicyChange()
+}
+
+// HedgePolicy es compartida por los market makers y el balancer. Con la
+// posicion neta fuera de +-trigger toneladas el balancer cubre hasta quedar
+// dentro de +-target y los market makers se pausan hasta que la posicion
+// vuelva a +-(trigger - hysteresis). Los settings los aplica solo el balancer;
+// los market makers se suscriben.
+type HedgePolicy struct {
+	rwMutex    sync.RWMutex
+	trigger    float64
+	target     float64
+	hysteresis float64
+	//0 manda la cobertura en una sola orden
+	maxChildQty float64
+	//cubrir el residuo menor a un std con minis
+	useMinis bool
+	//ultimos valores pedidos por settings; se aplican juntos cuando son validos
+	requested [3]float64
+	listeners []HedgePolicyListener
+}
+
+func NewHedgePolicy(trigger float64, target float64, hysteresis float64) (*HedgePolicy, error) {
+	hp := &HedgePolicy{}
+	if err := hp.Set(trigger, target, hysteresis); err != nil {
+		return nil, err
+	}
+	return hp, nil
+}
+
+func NewDefaultHedgePolicy() *HedgePolicy {
+	return &HedgePolicy{
+		trigger:    60,
+		target:     40,
+		hysteresis: 0,
+		requested:  [3]float64{60, 40, 0},
+	}
+}
+
+func (hp *HedgePolicy) Set(trigger float64, target float64, hysteresis float64) error {
+	if err := validateHedgeLevels(trigger, target, hysteresis); err != nil {
+		return err
+	}
+
+	hp.rwMutex.Lock()
+	hp.trigger = trigger
+	hp.target = target
+	hp.hysteresis = hysteresis
+	hp.requested = [3]float64{trigger, target, hysteresis}
+	hp.rwMutex.Unlock()
+	return nil
+}
+
+func validateHedgeLevels(trigger float64, target float64, hysteresis float64) error {
+	if trigger <= 0.0 {
+		return fmt.Errorf("hedge trigger must be positive: %v", trigger)
+	}
+	if target < 0.0 || target >= trigger {
+		return fmt.Errorf("hedge target %v must be in [0, %v)", target, trigger)
+	}
+	if hysteresis < 0.0 || hysteresis > trigger-target {
+		return fmt.Errorf("hedge hysteresis %v must be in [0, %v]", hysteresis, trigger-target)
+	}
+	return nil
+}
+
+func (hp *HedgePolicy) Get() (float64, float64, float64) {
+	hp.rwMutex.RLock()
+	defer hp.rwMutex.RUnlock()
+	return hp.trigger, hp.target, hp.hysteresis
+}
+
+func (hp *HedgePolicy) SetMaxChildQty(maxChildQty float64) error {
+	if !(maxChildQty >= 0.0) || math.IsInf(maxChildQty, 0) {
+		return fmt.Errorf("max child qty must not be negative: %v", maxChildQty)
+	}
+
+	hp.rwMutex.Lock()
+	hp.maxChildQty = maxChildQty
+	hp.rwMutex.Unlock()
+	return nil
+}
+
+func (hp *HedgePolicy) MaxChildQty() float64 {
+	hp.rwMutex.RLock()
+	defer hp.rwMutex.RUnlock()
+	return hp.maxChildQty
+}
+
+func (hp *HedgePolicy) SetUseMinis(useMinis bool) {
+	hp.rwMutex.Lock()
+	hp.useMinis = useMinis
+	hp.rwMutex.Unlock()
+}
+
+func (hp *HedgePolicy) UseMinis() bool {
+	hp.rwMutex.RLock()
+	defer hp.rwMutex.RUnlock()
+	return hp.useMinis
+}
+
+func (hp *HedgePolicy) Target() float64 {
+	hp.rwMutex.RLock()
+	defer hp.rwMutex.RUnlock()
+	return hp.target
+}
+
+// Unbalanced indica si la posicion requiere cobertura. Una vez desbalanceada
+// se mantiene asi hasta bajar de trigger - hysteresis.
+func (hp *HedgePolicy) Unbalanced(netQty float64, wasUnbalanced bool) bool {
+	hp.rwMutex.RLock()
+	defer hp.rwMutex.RUnlock()
+	if math.Abs(netQty) >= hp.trigger {
+		return true
+	}
+	return wasUnbalanced && math.Abs(netQty) > hp.trigger-hp.hysteresis
+}
+
+func (hp *HedgePolicy) Subscribe(listener HedgePolicyListener) {
+	hp.rwMutex.Lock()
+	hp.listeners = append(hp.listeners, listener)
+	hp.rwMutex.Unlock()
+}
+
+// ApplyBotSetting devuelve false si la clave no corresponde a la politica. Si
+// el setting se aplica avisa a los suscriptores, fuera del lock.
+func (hp *HedgePolicy) ApplyBotSetting(botSetting settings.BotSetting) (bool, error) {
+	applied, err := hp.applyBotSetting(botSetting)
+	if !applied || err != nil {
+		return applied, err
+	}
+
+	hp.rwMutex.RLock()
+	listeners := hp.listeners
+	hp.rwMutex.RUnlock()
+	for _, listener := range listeners {
+		listener.OnHedgePolicyChange()
+	}
+	return true, nil
+}
+
+// applyBotSetting valida trigger, target e hysteresis juntos con los ultimos
+// valores pedidos, no con los vigentes: si llegan trigger 30 y target 20 con
+// target vigente 40 el trigger queda pendiente hasta que llega el target.
+func (hp *HedgePolicy) applyBotSetting(botSetting settings.BotSetting) (bool, error) {
+	index := 0
+	switch botSetting.Key {
+	case HEDGE_TRIGGER:
+		index = 0
+	case HEDGE_TARGET:
+		index = 1
+	case HEDGE_HYSTERESIS:
+		index = 2
+	case HEDGE_MAX_CHILD:
+		return true, hp.SetMaxChildQty(botSetting.Value)
+	case HEDGE_USE_MINIS:
+		hp.SetUseMinis(botSetting.Value == 1)
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	hp.rwMutex.Lock()
+	hp.requested[index] = botSetting.Value
+	requested := hp.requested
+	hp.rwMutex.Unlock()
+	if err := validateHedgeLevels(requested[0], requested[1], requested[2]); err != nil {
+		return true, fmt.Errorf("%v, pending until the hedge levels agree", err)
+	}
+	return true, hp.Set(requested[0], requested[1], requested[2])
+}
+
+func isHedgeLevel(key settings.Key) bool {
+	return key == HEDGE_TRIGGER || key == HEDGE_TARGET || key == HEDGE_HYSTERESIS
+}
+
+// HedgeContracts es la menor cantidad de contratos de contractSize toneladas
+// que deja la posicion neta dentro de +-target. Si ninguna cantidad lo logra
+// se usa la que deja el menor residuo y, a igual residuo, la menor; puede ser
+// 0 si un contrato deja la posicion peor de lo que estaba.
+func HedgeContracts(netQty float64, target float64, contractSize float64) float64 {
+	exposure := math.Abs(netQty)
+	if exposure <= target {
+		return 0.0
+	}
+
+	contracts := math.Ceil((exposure - target) / contractSize)
+	if math.Abs(exposure-contracts*contractSize) > target {
+		lower := contracts - 1
+		if math.Abs(exposure-lower*contractSize) <= math.Abs(exposure-contracts*contractSize) {
+			contracts = lower
+		}
+	}
+	return contracts
+}
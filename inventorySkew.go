@@ -0,0 +1,61 @@
+package minis
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/deltafund/components-support/settings"
+)
+
+const (
+	SKEW_TICKS_PER_10_TONS = "SKEW_TICKS_PER_10_TONS"
+	SKEW_MAX_TICKS         = "SKEW_MAX_TICKS"
+)
+
+// InventorySkew desplaza el precio de ambas puntas en contra de la posicion
+// neta en toneladas: comprado baja bid y ask, vendido los sube. El valor cero
+// no desplaza nada y es el default del market maker.
+type InventorySkew struct {
+	TicksPer10Tons float64
+	MaxTicks       float64
+}
+
+func NewInventorySkew(ticksPer10Tons float64, maxTicks float64) (InventorySkew, error) {
+	if !(ticksPer10Tons > 0.0) || math.IsInf(ticksPer10Tons, 0) {
+		return InventorySkew{}, fmt.Errorf("inventory skew ticks per 10 tons must be positive: %v", ticksPer10Tons)
+	}
+	if !(maxTicks >= 0.0) || math.IsInf(maxTicks, 0) {
+		return InventorySkew{}, fmt.Errorf("inventory skew max ticks must not be negative: %v", maxTicks)
+	}
+	return InventorySkew{
+		TicksPer10Tons: ticksPer10Tons,
+		MaxTicks:       maxTicks,
+	}, nil
+}
+
+// Offset es el desplazamiento en precio para la posicion neta.
+func (s InventorySkew) Offset(netQty float64, tickSize float64) float64 {
+	ticks := netQty / 10 * s.TicksPer10Tons
+	ticks = math.Max(math.Min(ticks, s.MaxTicks), -s.MaxTicks)
+	return ticks * tickSize
+}
+
+// ApplyBotSetting cambia un parametro por vez; con cualquiera de los dos en 0
+// el skew queda apagado, asi el orden en que llegan no importa.
+func (s *InventorySkew) ApplyBotSetting(botSetting settings.BotSetting) (bool, error) {
+	switch botSetting.Key {
+	case SKEW_TICKS_PER_10_TONS, SKEW_MAX_TICKS:
+	default:
+		return false, nil
+	}
+	if !(botSetting.Value >= 0.0) || math.IsInf(botSetting.Value, 0) {
+		return true, fmt.Errorf("inventory skew setting must not be negative: %+v", botSetting)
+	}
+
+	if botSetting.Key == SKEW_TICKS_PER_10_TONS {
+		s.TicksPer10Tons = botSetting.Value
+	} else {
+		s.MaxTicks = botSetting.Value
+	}
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package minis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/components-support/settings"
+)
+
+func TestInventorySkewOffset(t *testing.T) {
+	skew, err := NewInventorySkew(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		skew   InventorySkew
+		netQty float64
+		offset float64
+	}{
+		{"flat", skew, 0, 0},
+		{"long one step", skew, 10, 0.1},
+		{"short one step", skew, -10, -0.1},
+		{"long clamped", skew, 80, 0.5},
+		{"short clamped", skew, -80, -0.5},
+		{"default", InventorySkew{}, 80, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if offset := tt.skew.Offset(tt.netQty, 0.1); math.Abs(offset-tt.offset) > 1e-9 {
+				t.Fatalf("Offset(%v) = %v, want %v", tt.netQty, offset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestNewInventorySkewValidates(t *testing.T) {
+	tests := []struct {
+		step    float64
+		max     float64
+		wantErr bool
+	}{
+		{1, 5, false},
+		{1, 0, false},
+		{0, 5, true},
+		{-1, 5, true},
+		{1, -1, true},
+		{math.NaN(), 5, true},
+	}
+	for _, tt := range tests {
+		if _, err := NewInventorySkew(tt.step, tt.max); (err != nil) != tt.wantErr {
+			t.Errorf("NewInventorySkew(%v, %v) error = %v", tt.step, tt.max, err)
+		}
+	}
+}
+
+func TestMarketMakerInventorySkewFromBotSettings(t *testing.T) {
+	mm, _ := newTestMarketMaker(t, order.Side_BUY)
+	if mm.inventorySkew != (InventorySkew{}) {
+		t.Fatalf("default skew = %+v, want none", mm.inventorySkew)
+	}
+
+	mm.OnBotSettingChange(settings.BotSetting{Key: SKEW_MAX_TICKS, Value: 5})
+	mm.OnBotSettingChange(settings.BotSetting{Key: SKEW_TICKS_PER_10_TONS, Value: 1})
+	if want := (InventorySkew{TicksPer10Tons: 1, MaxTicks: 5}); mm.inventorySkew != want {
+		t.Fatalf("skew = %+v, want %+v", mm.inventorySkew, want)
+	}
+}
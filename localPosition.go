@@ -1,11 +1,18 @@
 package minis
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/deltafund/api-fix/order"
 	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/broker"
 	"github.com/deltafund/components-support/position"
+	"github.com/deltafund/components-support/settings"
 )
 
 const (
@@ -13,21 +20,73 @@ const (
 	TONS_POSITION       string = "tons-position"
 )
 
-type netFuturePos struct {
-	stdSecurity  security.Security
-	miniSecurity security.Security
-	position     position.Position
+// PositionSource da la posicion total, historica mas la del dia, para
+// reconciliar despues de una reconexion.
+type PositionSource interface {
+	Position() position.Position
 }
 
-func NewNetFuturePos(stdSecurity security.Security, miniSecurity security.Security, initPosition position.Position) *netFuturePos {
-	return &netFuturePos{
-		stdSecurity:  stdSecurity,
-		miniSecurity: miniSecurity,
-		position:     initPosition,
+type TonsPosition struct {
+	mutex    sync.Mutex
+	security security.Security
+	position position.Position
+	//posicion de dias anteriores en toneladas
+	historical       position.Position
+	historicalLoaded bool
+	specs            *ContractSpecs
+}
+
+// NewTonsPosition pasa contratos a toneladas con el spec del instrumento en
+// specs; sin spec usa el tamano por defecto de su mercado.
+func NewTonsPosition(security security.Security, position position.Position, specs *ContractSpecs) *TonsPosition {
+	if specs == nil {
+		specs = NewContractSpecs()
 	}
+	return &TonsPosition{
+		security: security,
+		position: position,
+		specs:    specs}
+}
+
+// Position es la posicion total: la historica mas la del dia.
+func (tp *TonsPosition) Position() position.Position {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	return addPositions(tp.historical, tp.position)
+}
+
+func (tp *TonsPosition) Intraday() position.Position {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	return tp.position
+}
+
+// LoadHistorical toma la posicion de dias anteriores del instrumento, en
+// contratos, y devuelve el evento que la publica.
+func (tp *TonsPosition) LoadHistorical(historicalSecurityPositions map[string]position.Position) position.PositionEvent {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	oldTotal := addPositions(tp.historical, tp.position)
+	tp.loadHistorical(historicalSecurityPositions)
+	return startupEvent(tp.position, oldTotal, addPositions(tp.historical, tp.position))
+}
+
+func (tp *TonsPosition) loadHistorical(historicalSecurityPositions map[string]position.Position) {
+	tp.historical = contractsToTons(historicalSecurityPositions[tp.security.Symbol], tp.specs.TonsPerContract(tp.security))
+	tp.historicalLoaded = true
 }
 
-func (nfp *netFuturePos) ConsumeExecution(
+// Resync toma la posicion total del instrumento, en contratos, y deja como
+// posicion del dia lo que no es historica.
+func (tp *TonsPosition) Resync(securityPositions map[string]position.Position) {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	total := contractsToTons(securityPositions[tp.security.Symbol], tp.specs.TonsPerContract(tp.security))
+	tp.position = subtractPositions(total, tp.historical)
+	tp.historicalLoaded = true
+}
+
+func (tp *TonsPosition) ConsumeExecution(
 	orderEvent order.OrderEvent,
 	securityPositions map[string]position.Position,
 	syntheticPositions map[string]position.Position,
@@ -35,111 +94,296 @@ func (nfp *netFuturePos) ConsumeExecution(
 	historicalSyntheticPositions map[string]position.Position,
 ) *position.PositionEvent {
 
-	fmt.Printf("%s ConsumeExecution OrderEvent %+v\n", nfp.miniSecurity.Symbol, orderEvent)
+	if orderEvent.Order.Security.Symbol != tp.security.Symbol {
+		return nil
+	}
 
-	oldPosition := nfp.position
-	switch orderEvent.Order.Security.Symbol {
-	case nfp.stdSecurity.Symbol:
+	//la historica se carga con el mismo lock que el fill, asi no se pisa con la del arranque
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	if !tp.historicalLoaded && len(historicalSecurityPositions) > 0 {
+		tp.loadHistorical(historicalSecurityPositions)
+	}
+	return tp.apply(orderEvent, 1)
+}
 
-		if orderEvent.ExecutionReport.Side == order.Side_BUY {
-			nfp.position.BuyQty += (orderEvent.Qty * 100)
-			//nfp.position.AvgBuyPx = (nfp.position.AvgBuyPx + avgPx) / nfp.position.BuyQty
-		}
+// ReverseExecution deshace una ejecucion cancelada por el mercado (trade bust).
+func (tp *TonsPosition) ReverseExecution(orderEvent order.OrderEvent) *position.PositionEvent {
+	if orderEvent.Order.Security.Symbol != tp.security.Symbol {
+		return nil
+	}
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	return tp.apply(orderEvent, -1)
+}
 
-		if orderEvent.ExecutionReport.Side == order.Side_SELL {
-			nfp.position.SellQty += (orderEvent.Qty * 100)
-			//nfp.position.AvgSellPx = (nfp.position.AvgSellPx + avgPx) / nfp.position.SellQty
-		}
-	case nfp.miniSecurity.Symbol:
-		if orderEvent.ExecutionReport.Side == order.Side_BUY {
-			nfp.position.BuyQty += (orderEvent.Qty * 10)
-			//nfp.position.AvgBuyPx = (nfp.position.AvgBuyPx + avgPx) / nfp.position.BuyQty
-		}
+// apply se llama con el lock tomado.
+func (tp *TonsPosition) apply(orderEvent order.OrderEvent, sign float64) *position.PositionEvent {
+	oldPosition := tp.position
+	sizePerContract := tp.specs.TonsPerContract(tp.security)
 
-		if orderEvent.ExecutionReport.Side == order.Side_SELL {
-			nfp.position.SellQty += (orderEvent.Qty * 10)
-			//nfp.position.AvgSellPx = (nfp.position.AvgSellPx + avgPx) / nfp.position.SellQty
-		}
+	addTons(&tp.position, orderEvent.ExecutionReport.Side, sign*(orderEvent.ExecutionReport.Qty*sizePerContract), orderEvent.ExecutionReport.Px)
 
-	default:
-		//ignore trade
-	}
-	nfp.position.NetQty = nfp.position.BuyQty - nfp.position.SellQty
-	//en las cantidades de OrderEvent, sumar o restar cantidades a positionEvent (newHistoricalPos)
-	//return &position.PositionEvent{}
 	return &position.PositionEvent{
-		OldPosition: oldPosition,
-		NewPosition: nfp.position,
+		OldPosition:           oldPosition,
+		NewPosition:           tp.position,
+		OldHistoricalPosition: addPositions(tp.historical, oldPosition),
+		NewHistoricalPosition: addPositions(tp.historical, tp.position),
 	}
 }
 
-type TonsPosition struct {
-	security security.Security
-	position position.Position
+// addTons suma tons a px del lado side de p, o las resta si son negativas, y
+// recalcula el precio promedio de ese lado ponderado por toneladas. Asi un
+// fill de 10 contratos de mini pesa lo mismo que uno de 1 std.
+func addTons(p *position.Position, side order.Side, tons float64, px float64) {
+	if side == order.Side_BUY {
+		p.BuyQty, p.AvgBuyPx = addToAvg(p.BuyQty, p.AvgBuyPx, tons, px)
+	} else {
+		p.SellQty, p.AvgSellPx = addToAvg(p.SellQty, p.AvgSellPx, tons, px)
+	}
+	p.NetQty = p.BuyQty - p.SellQty
 }
 
-func NewTonsPosition(security security.Security, position position.Position) *TonsPosition {
-	return &TonsPosition{
-		security: security,
-		position: position}
+func addToAvg(qty float64, avgPx float64, tons float64, px float64) (float64, float64) {
+	newQty := qty + tons
+	if math.Abs(newQty) < 1e-9 {
+		return 0.0, 0.0
+	}
+	if px <= 0.0 {
+		return newQty, avgPx
+	}
+	return newQty, (avgPx*qty + px*tons) / newQty
 }
 
-func (tp *TonsPosition) ConsumeExecution(
-	orderEvent order.OrderEvent,
-	securityPositions map[string]position.Position,
-	syntheticPositions map[string]position.Position,
-	historicalSecurityPositions map[string]position.Position,
-	historicalSyntheticPositions map[string]position.Position,
-) *position.PositionEvent {
+func addPositions(a position.Position, b position.Position) position.Position {
+	total := a
+	addTons(&total, order.Side_BUY, b.BuyQty, b.AvgBuyPx)
+	addTons(&total, order.Side_SELL, b.SellQty, b.AvgSellPx)
+	return total
+}
 
-	//fmt.Printf("%s TonsPosition ConsumeExecution OrderEvent %+v\n", &tp.security.Symbol, orderEvent)
-	fmt.Println("tp.Security.symbol : ", tp.security.Symbol, "order event sec symbol : ", orderEvent.Order.Security.Symbol)
-	if orderEvent.Order.Security.Symbol != tp.security.Symbol {
-		fmt.Println("nil")
-		return nil
+// subtractPositions es la inversa de addPositions: a menos b con los precios
+// promedio de lo que queda.
+func subtractPositions(a position.Position, b position.Position) position.Position {
+	rest := a
+	addTons(&rest, order.Side_BUY, -b.BuyQty, b.AvgBuyPx)
+	addTons(&rest, order.Side_SELL, -b.SellQty, b.AvgSellPx)
+	return rest
+}
+
+// startupEvent publica la posicion con la historica recien cargada: el total
+// pasa de oldTotal a newTotal sin que cambie la del dia.
+func startupEvent(intraday position.Position, oldTotal position.Position, newTotal position.Position) position.PositionEvent {
+	return position.PositionEvent{
+		OldPosition:           intraday,
+		NewPosition:           intraday,
+		OldHistoricalPosition: oldTotal,
+		NewHistoricalPosition: newTotal,
 	}
+}
 
-	oldPosition := tp.position
-	sizePerContract := 100.0
-	if tp.security.Harbour == "MIN" {
-		sizePerContract = 10.0
+func contractsToTons(p position.Position, sizePerContract float64) position.Position {
+	tons := position.Position{}
+	addTons(&tons, order.Side_BUY, p.BuyQty*sizePerContract, p.AvgBuyPx)
+	addTons(&tons, order.Side_SELL, p.SellQty*sizePerContract, p.AvgSellPx)
+	return tons
+}
+
+// LoadHistoricalPositions lee las posiciones de dias anteriores por ticker, en
+// contratos, de un JSON como {"SOJ.ROS/MAY24": {"BuyQty": 3, "AvgBuyPx": 300}}.
+func LoadHistoricalPositions(path string) (map[string]position.Position, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]position.Position)
+	if err := json.Unmarshal(data, &positions); err != nil {
+		return nil, fmt.Errorf("cannot parse historical positions file %s: %v", path, err)
 	}
+	for symbol, p := range positions {
+		p.NetQty = p.BuyQty - p.SellQty
+		positions[symbol] = p
+	}
+	return positions, nil
+}
 
-	if orderEvent.Order.Side == order.Side_BUY {
-		tp.position.BuyQty += (orderEvent.ExecutionReport.Qty * sizePerContract)
-	} else {
-		tp.position.SellQty += (orderEvent.ExecutionReport.Qty * sizePerContract)
+// PositionLoader devuelve las posiciones totales por ticker en contratos, con
+// el formato de LoadHistoricalPositions. Se usa para volver a leer las
+// posiciones del broker al reconectar.
+type PositionLoader func() (map[string]position.Position, error)
+
+// PositionResync vuelve a leer las posiciones con loader y las pasa a las
+// posiciones locales, para no perder los fills de mientras se estuvo
+// desconectado. Es idempotente, cada estrategia lo llama al reconectar.
+type PositionResync struct {
+	loader    PositionLoader
+	netFuture *NetFuturePosition
+	tons      []*TonsPosition
+}
+
+func NewPositionResync(loader PositionLoader, netFuture *NetFuturePosition, tons ...*TonsPosition) *PositionResync {
+	return &PositionResync{
+		loader:    loader,
+		netFuture: netFuture,
+		tons:      tons,
 	}
-	tp.position.NetQty = tp.position.BuyQty - tp.position.SellQty
+}
 
-	fmt.Printf("OldPosition %+v\n newPos : %+v\n", oldPosition, tp.position)
-	return &position.PositionEvent{
-		OldPosition: oldPosition,
-		NewPosition: tp.position,
+func (pr *PositionResync) Resync() error {
+	positions, err := pr.loader()
+	if err != nil {
+		return fmt.Errorf("cannot load positions: %v", err)
+	}
+	if pr.netFuture != nil {
+		pr.netFuture.Resync(positions)
+	}
+	for _, tp := range pr.tons {
+		if tp != nil {
+			tp.Resync(positions)
+		}
 	}
+	return nil
 }
 
-func StartSubscriptions(settingsManager *settings.SettingsManager, myBroker broker.DefaultBroker, positionManager position.IPositionManager, sec security.Security, stdFuture security.Security, mmBuys *marketmaker.MinisMarketMaker, mmSells *marketmaker.MinisMarketMaker, balancers *balancer.Balancer) {
-	positionManager.SubscribeSyntheticPosition(sec.Symbol+"-"+NET_FUTURE_POSITION, mmBuys)
-	positionManager.SubscribeSyntheticPosition(sec.Symbol+"-"+NET_FUTURE_POSITION, mmSells)
-	positionManager.SubscribeSyntheticPosition(sec.Symbol+"-"+NET_FUTURE_POSITION, balancers)
+// SubscriptionConfig son las piezas que conecta StartSubscriptions. Security
+// es el mini; el std se deriva de el con Symbols, nil usa NewSymbolMap, y el
+// Balancer tiene que cubrir con ese mismo std.
+type SubscriptionConfig struct {
+	SettingsManager *settings.SettingsManager
+	Broker          broker.DefaultBroker
+	PositionManager position.IPositionManager
+	Security        security.Security
+	Symbols         *SymbolMap
+	MMBuys          *MinisMarketMaker
+	MMSells         *MinisMarketMaker
+	Balancer        *Balancer
+	//pueden ser nil; MiniTons y StdTons son las registradas en el position manager
+	NetFuture *NetFuturePosition
+	MiniTons  *TonsPosition
+	StdTons   *TonsPosition
+	//posiciones de dias anteriores por ticker (LoadHistoricalPositions), se
+	//cargan y se publican para que el balancer cubra al arrancar
+	Historical map[string]position.Position
+	//vuelve a leer las posiciones en cada reconexion antes de cotizar
+	PositionLoader PositionLoader
+	//recibe las alertas del watchdog y las respuestas a los comandos
+	TraderUpdater TraderUpdater
+	//0 usa DEFAULT_ACK_TIMEOUT
+	AckTimeout time.Duration
+}
+
+// StartSubscriptions devuelve el TradeBustHandler, que corrige las posiciones
+// locales y avisa a sus suscriptores, y el Watchdog de acks ya arrancado.
+func StartSubscriptions(config SubscriptionConfig) (*TradeBustHandler, *Watchdog, error) {
+	settingsManager := config.SettingsManager
+	myBroker := config.Broker
+	positionManager := config.PositionManager
+	sec := config.Security
+	symbols := config.Symbols
+	mmBuys, mmSells, balancers := config.MMBuys, config.MMSells, config.Balancer
+	netFuture, miniTons, stdTons := config.NetFuture, config.MiniTons, config.StdTons
+	traderUpdater := config.TraderUpdater
+	if symbols == nil {
+		symbols = NewSymbolMap()
+	}
+	stdFuture, err := symbols.StdSecurity(sec)
+	if err != nil {
+		return nil, nil, err
+	}
+	netFutureSymbol := sec.Symbol + "-" + NET_FUTURE_POSITION
+	if netFuture != nil && netFuture.Symbol() != netFutureSymbol {
+		return nil, nil, fmt.Errorf("net future position %s must be named %s", netFuture.Symbol(), netFutureSymbol)
+	}
+	//el balancer cubre con el std que recibio; tiene que ser el mismo que usan los MM
+	if balancers.security.Symbol != stdFuture.Symbol {
+		return nil, nil, fmt.Errorf("balancer hedges %s but %s is hedged with %s", balancers.security.Symbol, sec.Symbol, stdFuture.Symbol)
+	}
+
+	mmBuys.SetStdSecurity(stdFuture)
+	mmSells.SetStdSecurity(stdFuture)
+
+	positionManager.SubscribeSyntheticPosition(netFutureSymbol, mmBuys)
+	positionManager.SubscribeSyntheticPosition(netFutureSymbol, mmSells)
+	positionManager.SubscribeSyntheticPosition(netFutureSymbol, balancers)
 
 	myBroker.SubscribeSymbolSide(sec, order.Side_BUY, balancers)
 	myBroker.SubscribeSymbolSide(sec, order.Side_SELL, balancers)
 
 	myBroker.SubscribeBook(stdFuture, mmBuys)
 	myBroker.SubscribeBook(stdFuture, mmSells)
+	myBroker.SubscribeBook(stdFuture, balancers)
+	myBroker.SubscribeBook(sec, balancers)
 
 	myBroker.SubscribeExchange(security.Exchange_ROFEX, mmBuys)
 	myBroker.SubscribeExchange(security.Exchange_ROFEX, mmSells)
 	myBroker.SubscribeExchange(security.Exchange_ROFEX, balancers)
 
-	//positionSaver tiene metodos para traer mapas con posiciones (para enviar como initPos en NewNetFuturePos..)
+	bustHandler := NewTradeBustHandler([]string{mmBuys.account, mmSells.account, balancers.account})
+	if netFuture != nil {
+		mmBuys.SetPositionSource(netFuture)
+		mmSells.SetPositionSource(netFuture)
+		balancers.SetPositionSource(netFuture)
+		bustHandler.AddSynthetic(netFutureSymbol, netFuture, mmBuys, mmSells, balancers)
+	}
+	//el position manager no se entera de los trade cancel, se avisa a los mismos suscriptores
+	if miniTons != nil {
+		bustHandler.AddSecurity(sec, miniTons, mmBuys, mmSells)
+	}
+	if stdTons != nil {
+		bustHandler.AddSecurity(stdFuture, stdTons)
+	}
+	myBroker.SubscribeExchange(security.Exchange_ROFEX, bustHandler)
+
 	positionManager.SubscribeSecurityPosition(sec, mmBuys)
 	positionManager.SubscribeSecurityPosition(sec, mmSells)
 
+	mmBuys.SetSettingsManager(settingsManager)
+	mmSells.SetSettingsManager(settingsManager)
+	balancers.SetSettingsManager(settingsManager)
+	if traderUpdater != nil {
+		mmBuys.SetTraderUpdater(traderUpdater)
+		mmSells.SetTraderUpdater(traderUpdater)
+		balancers.SetTraderUpdater(traderUpdater)
+	}
 	settingsManager.Subscribe(mmBuys)
 	settingsManager.Subscribe(mmSells)
 	settingsManager.Subscribe(balancers)
 
+	if historical := config.Historical; historical != nil {
+		if netFuture != nil {
+			event := netFuture.LoadHistorical(historical)
+			for _, listener := range []SyntheticPositionListener{mmBuys, mmSells, balancers} {
+				listener.OnSyntheticPositionChange(netFutureSymbol, event)
+			}
+		}
+		if miniTons != nil {
+			event := miniTons.LoadHistorical(historical)
+			mmBuys.OnSecurityPositionChange(sec, event)
+			mmSells.OnSecurityPositionChange(sec, event)
+		}
+		if stdTons != nil {
+			stdTons.LoadHistorical(historical)
+		}
+	}
+
+	if config.PositionLoader != nil {
+		positionResync := NewPositionResync(config.PositionLoader, netFuture, miniTons, stdTons)
+		mmBuys.SetPositionResync(positionResync)
+		mmSells.SetPositionResync(positionResync)
+		balancers.SetPositionResync(positionResync)
+	}
+
+	ackTimeout := config.AckTimeout
+	if ackTimeout <= 0 {
+		ackTimeout = DEFAULT_ACK_TIMEOUT
+	}
+	var alert func(string)
+	if traderUpdater != nil {
+		alert = traderUpdater.SendToast
+	}
+	watchdog := NewWatchdog(nil, ackTimeout, ackTimeout/2, alert)
+	watchdog.Register(mmBuys, mmSells, balancers)
+	watchdog.Start()
+
+	return bustHandler, watchdog, nil
 }
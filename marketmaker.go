@@ -1,12 +1,13 @@
 package minis
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/deltafund/api-fix/marketdata"
 	"github.com/deltafund/api-fix/order"
 	"github.com/deltafund/api-fix/security"
-	"github.com/deltafund/api-fix/utils"
 	"github.com/deltafund/components-support/broker"
 	"github.com/deltafund/components-support/position"
 	"github.com/deltafund/components-support/settings"
@@ -23,14 +24,18 @@ type MinisMarketMaker struct {
 	broker       broker.Broker
 	logger       *storage.Logger
 
-	activeOrder *order.Order
-	sentOrder   *order.Order
+	slot *OrderSlot
 
-	px         float64
-	qty        float64
-	qtyDefault float64
-	//netQty          float64
+	px              float64
+	qty             float64
+	netQty          float64
 	automaticSpread float64
+	spreadModel     SpreadModel
+	vol             float64
+	params          *ParamStore
+	specs           *ContractSpecs
+	hedgePolicy     *HedgePolicy
+	inventorySkew   InventorySkew
 	avgBuyPx        float64
 	avgSellPx       float64
 	//Switchs
@@ -38,44 +43,84 @@ type MinisMarketMaker struct {
 	automaticSpreadEnabled bool
 	enabled                bool
 	unbalanced             bool
+	connected              bool
+	//FLATTEN: no cotiza hasta un RESUME del lado
+	stopped bool
 
 	mktPx           float64
 	rwMutex         sync.RWMutex
 	settingsManager *settings.SettingsManager
-
-	pendingCancel  bool
-	cancelRejected bool
+	traderUpdater   TraderUpdater
+	positionSource  PositionSource
+	positionResync  *PositionResync
+	phases          *PhaseTracker
+	siblingNetQty   float64
 }
 
 func NewMinisMarketMaker(securityFuture security.Security,
-	side order.Side, account string, broker broker.Broker) *MinisMarketMaker {
+	side order.Side, account string, broker broker.Broker, spreadModel SpreadModel, params *ParamStore, specs *ContractSpecs, hedgePolicy *HedgePolicy) *MinisMarketMaker {
+
+	if spreadModel == nil {
+		spreadModel = NewDefaultSpreadModel()
+	}
+	if sideModel, ok := spreadModel.(SideSpreadModel); ok {
+		spreadModel = sideModel.ForSide()
+	}
+	if params == nil {
+		params, _ = NewParamStore("")
+	}
+	if specs == nil {
+		specs = NewContractSpecs()
+	}
+	if hedgePolicy == nil {
+		hedgePolicy = NewDefaultHedgePolicy()
+	}
 
 	loggerName := "market-maker-buy"
 	if side == order.Side_SELL {
 		loggerName = "market-maker-sell"
 	}
 
-	return &MinisMarketMaker{
-		logger:       storage.NewLogger(loggerName),
-		miniSecurity: securityFuture,
-		side:         side,
-		account:      account,
-		broker:       broker,
-		px:           0.0,
-		qty:          0.0,
-		mktPx:        0.0,
-		qtyDefault:   10.0,
-		//netQty:                 0.0,
+	mm := &MinisMarketMaker{
+		logger:                 storage.NewLogger(loggerName),
+		miniSecurity:           securityFuture,
+		side:                   side,
+		account:                account,
+		broker:                 broker,
+		px:                     0.0,
+		qty:                    0.0,
+		mktPx:                  0.0,
+		netQty:                 0.0,
 		automaticSpread:        0.0,
+		spreadModel:            spreadModel,
+		vol:                    params.Side(securityFuture.Symbol, side).Vol,
+		params:                 params,
+		specs:                  specs,
+		hedgePolicy:            hedgePolicy,
 		avgBuyPx:               0.0,
 		avgSellPx:              0.0,
-		pendingCancel:          false,
-		cancelRejected:         false,
 		unbalanced:             false,
+		connected:              true,
+		phases:                 NewPhaseTracker(),
 		enabledAll:             true, //en produccion inicializar en false
 		enabled:                true, //en produccion inicializar en false
 		automaticSpreadEnabled: true, //en produccion inicializar en false funcion OnAssetSettingChange
 	}
+	mm.slot = NewOrderSlot(broker, mm.logger, mm, NewSystemClock())
+	hedgePolicy.Subscribe(mm)
+	//el std que cotiza y cubre al mini, StartSubscriptions lo pisa con el del SymbolMap
+	if stdSecurity, err := NewSymbolMap().StdSecurity(securityFuture); err == nil {
+		mm.stdSecurity = stdSecurity
+	} else {
+		mm.logger.Printf("Cannot derive the std of %v: %v", securityFuture.Symbol, err)
+	}
+	return mm
+}
+
+func (mm *MinisMarketMaker) SetStdSecurity(stdSecurity security.Security) {
+	mm.rwMutex.Lock()
+	mm.stdSecurity = stdSecurity
+	mm.rwMutex.Unlock()
 }
 
 ///////////////// Order Callbacks ////////////////////////////////
@@ -83,13 +128,11 @@ func (mm *MinisMarketMaker) OnOrderPlaced(orderPlaced order.OrderPlaced) {
 	mm.logger.Printf("OnOrderPlaced: %+v", orderPlaced)
 
 	mm.rwMutex.Lock()
-	if mm.invalidEvent(orderPlaced.OrderEvent) {
+	if !mm.slot.OnPlaced(orderPlaced.OrderEvent) {
 		mm.rwMutex.Unlock()
 		return
 	}
 
-	mm.sentOrder = nil
-	mm.activeOrder = &orderPlaced.Order
 	mm.px = mm.calculatePx()
 	mm.qty = mm.calculateQty()
 	mm.rebalance()
@@ -98,15 +141,21 @@ func (mm *MinisMarketMaker) OnOrderPlaced(orderPlaced order.OrderPlaced) {
 
 func (mm *MinisMarketMaker) OnOrderPlaceRejected(orderPlaceRejected order.OrderPlaceRejected) {
 	mm.logger.Printf("OnOrderPlaceRejected: %+v", orderPlaceRejected)
+	alert := ""
 	mm.rwMutex.Lock()
-	if mm.invalidEvent(orderPlaceRejected.OrderEvent) {
-		mm.rwMutex.Unlock()
-		return
+	if mm.slot.OnPlaceRejected(orderPlaceRejected.OrderEvent) && mm.slot.Rejected() {
+		alert = fmt.Sprintf("%v %v: %d orders rejected in a row, quoting stopped until %v",
+			mm.miniSecurity.Symbol, mm.side, MAX_PLACE_REJECTS, CMD_RESET_STATE)
 	}
-	mm.pendingCancel = true
-	mm.sentOrder = nil
-	mm.rebalance()
+	traderUpdater := mm.traderUpdater
 	mm.rwMutex.Unlock()
+
+	if alert != "" {
+		mm.logger.Printf("%s", alert)
+		if traderUpdater != nil {
+			traderUpdater.SendToast(alert)
+		}
+	}
 }
 
 func (mm *MinisMarketMaker) BeforeOrderPlacement(beforeOrderPlacement order.BeforeOrderPlacement) {
@@ -116,24 +165,22 @@ func (mm *MinisMarketMaker) BeforeOrderPlacement(beforeOrderPlacement order.Befo
 func (mm *MinisMarketMaker) OnOrderReplaced(orderReplaced order.OrderReplaced) {
 	mm.logger.Printf("OnOrderReplaced: %+v", orderReplaced)
 	mm.rwMutex.Lock()
-	if mm.invalidEvent(orderReplaced.OrderEvent) {
+	if !mm.slot.OnReplaced(orderReplaced.OrderEvent, orderReplaced.NewOrder) {
 		mm.rwMutex.Unlock()
 		return
 	}
-	mm.sentOrder = nil
-	mm.activeOrder = orderReplaced.NewOrder
 	mm.rebalance()
 	mm.rwMutex.Unlock()
 }
 func (mm *MinisMarketMaker) OnOrderReplaceRejected(orderReplaceRejected order.OrderReplaceRejected) {
 	mm.logger.Printf("OnOrderReplaceRejected: %+v", orderReplaceRejected)
 	mm.rwMutex.Lock()
-	if mm.invalidEvent(orderReplaceRejected.OrderEvent) {
+	if !mm.slot.OnReplaceRejected(orderReplaceRejected.OrderEvent) {
 		mm.rwMutex.Unlock()
 		return
 	}
-	mm.pendingCancel = true
-	mm.rebalance()
+	//se cancela y al confirmarse se vuelve a cotizar con una orden nueva
+	mm.removeOrder()
 	mm.rwMutex.Unlock()
 }
 func (mm *MinisMarketMaker) BeforeOrderReplacement(beforeOrderReplacement order.BeforeOrderReplacement) {
@@ -156,24 +203,19 @@ func (mm *MinisMarketMaker) OnSecurityPositionChange(security security.Security,
 func (mm *MinisMarketMaker) OnOrderCancelled(orderCancelled order.OrderCancelled) {
 	mm.logger.Printf("OnOrderCancelled: %+v", orderCancelled)
 	mm.rwMutex.Lock()
-	if mm.invalidEvent(orderCancelled.OrderEvent) {
+	if !mm.slot.OnCancelled(orderCancelled.OrderEvent) {
 		mm.rwMutex.Unlock()
 		return
 	}
-	mm.sentOrder = nil
-	mm.activeOrder = nil
+	mm.px = mm.calculatePx()
+	mm.qty = mm.calculateQty()
+	mm.rebalance()
 	mm.rwMutex.Unlock()
 }
 func (mm *MinisMarketMaker) OnOrderCancelRejected(orderCancelRejected order.OrderCancelRejected) {
 	mm.logger.Printf("OnOrderCancelRejected: %+v", orderCancelRejected)
 	mm.rwMutex.Lock()
-	if mm.invalidEvent(orderCancelRejected.OrderEvent) {
-		mm.rwMutex.Unlock()
-		return
-	}
-	mm.pendingCancel = true
-	mm.cancelRejected = true
-	mm.rebalance()
+	mm.slot.OnCancelRejected(orderCancelRejected.OrderEvent)
 	mm.rwMutex.Unlock()
 }
 func (mm *MinisMarketMaker) BeforeOrderCancellation(beforeOrderCancellation order.BeforeOrderCancellation) {
@@ -182,8 +224,10 @@ func (mm *MinisMarketMaker) BeforeOrderCancellation(beforeOrderCancellation orde
 func (mm *MinisMarketMaker) OnOrderFilled(orderFilled order.OrderFilled) {
 	mm.logger.Printf("OnOrderFilled: %+v", orderFilled)
 	mm.rwMutex.Lock()
-	mm.sentOrder = nil
-	mm.activeOrder = nil
+	if !mm.slot.OnFilled(orderFilled.OrderEvent) {
+		mm.rwMutex.Unlock()
+		return
+	}
 	mm.qty = mm.calculateQty()
 	mm.px = mm.calculatePx()
 	mm.rebalance()
@@ -191,30 +235,103 @@ func (mm *MinisMarketMaker) OnOrderFilled(orderFilled order.OrderFilled) {
 }
 
 func (mm *MinisMarketMaker) OnOrderPartiallyFilled(orderPartiallyFilled order.OrderPartiallyFilled) {
-	//mm.logger.Printf("orderPartiallyFilled: %+v", orderPartiallyFilled)
-	if mm.sentOrder != nil {
-		if mm.sentOrder.Qty == orderPartiallyFilled.Order.Qty && mm.sentOrder.Px == orderPartiallyFilled.Order.Px {
-			mm.sentOrder = nil
-			//mm.replaceOrder()
-		}
-	}
 	mm.rwMutex.Lock()
-	//mm.qty = mm.calculateQty()
+	if !mm.slot.OnPartiallyFilled(orderPartiallyFilled.OrderEvent) {
+		mm.rwMutex.Unlock()
+		return
+	}
 	mm.qty = mm.calculateQty()
 	mm.px = mm.calculatePx()
-	mm.activeOrder = &orderPartiallyFilled.Order
 	mm.logger.Printf("PartiallyFilled : mm.qty : %+v cumQty : %+v", mm.qty, orderPartiallyFilled.Order.CumQty)
-	mm.rebalance() //o replaceOrder
+	mm.rebalance()
+	mm.rwMutex.Unlock()
+}
+
+// OnOrderRegistered informa el estado de una orden; saca al slot de un cancel
+// rechazado.
+func (mm *MinisMarketMaker) OnOrderRegistered(orderRegistered order.OrderRegistered) {
+	mm.rwMutex.Lock()
+	if mm.slot.OnStatus(orderRegistered.OrderEvent) {
+		mm.px = mm.calculatePx()
+		mm.qty = mm.calculateQty()
+		mm.rebalance()
+	}
+	mm.rwMutex.Unlock()
+}
+func (mm *MinisMarketMaker) OnTradeCancel(tradeCancel order.TradeCancel) {
+	// la posicion la corrige el TradeBustHandler, que despues avisa por OnSyntheticPositionChange
+	mm.logger.Printf("OnTradeCancel: %+v", tradeCancel)
+}
+
+// OnStartFinish llega al terminar de conectar. Si hubo una desconexion se
+// cancelan las ordenes inciertas y se toma la posicion antes de cotizar.
+func (mm *MinisMarketMaker) OnStartFinish(exchange security.Exchange) {
+	mm.logger.Printf("%v OnStartFinish %v", mm.miniSecurity.Symbol, exchange)
+	alert := ""
+	mm.rwMutex.Lock()
+	mm.slot.Reconcile()
+	if mm.positionResync != nil {
+		if err := mm.positionResync.Resync(); err != nil {
+			alert = fmt.Sprintf("%v %v: %v, quoting with the last known position", mm.miniSecurity.Symbol, mm.side, err)
+		}
+	}
+	if mm.positionSource != nil {
+		mm.netQty = mm.positionSource.Position().NetQty
+		mm.unbalanced = mm.hedgePolicy.Unbalanced(mm.exposure(), mm.unbalanced)
+	}
+	mm.connected = true
+	mm.px = mm.calculatePx()
+	mm.qty = mm.calculateQty()
+	mm.rebalance()
+	traderUpdater := mm.traderUpdater
+	mm.rwMutex.Unlock()
+
+	if alert != "" {
+		mm.logger.Printf("%s", alert)
+		if traderUpdater != nil {
+			traderUpdater.SendToast(alert)
+		}
+	}
+}
+
+func (mm *MinisMarketMaker) SetPositionSource(positionSource PositionSource) {
+	mm.rwMutex.Lock()
+	mm.positionSource = positionSource
 	mm.rwMutex.Unlock()
 }
 
-func (mm *MinisMarketMaker) OnOrderRegistered(orderRegistered order.OrderRegistered) {}
-func (mm *MinisMarketMaker) OnTradeCancel(tradeCancel order.TradeCancel)             {}
-func (mm *MinisMarketMaker) OnStartFinish(exchange security.Exchange)                {}
+// SetPositionResync hace que al reconectar se vuelvan a leer las posiciones
+// antes de cotizar.
+func (mm *MinisMarketMaker) SetPositionResync(positionResync *PositionResync) {
+	mm.rwMutex.Lock()
+	mm.positionResync = positionResync
+	mm.rwMutex.Unlock()
+}
 
 func (mm *MinisMarketMaker) OnTradeFromAnotherAccount(tradeFromAnotherAccount order.TradeFromAnotherAccount) {
 }
 
+// SetFirmPosition activa el control de desbalance sobre la posicion de la
+// firma: la propia mas la de las cuentas hermanas.
+func (mm *MinisMarketMaker) SetFirmPosition(firmPosition *FirmPosition) {
+	//con el lock tomado un cambio que llegue ya suscripto espera a la lectura
+	mm.rwMutex.Lock()
+	mm.siblingNetQty = firmPosition.SubscribeAndGet(mm)
+	mm.rwMutex.Unlock()
+}
+
+func (mm *MinisMarketMaker) OnFirmPositionChange(siblingNetQty float64) {
+	mm.rwMutex.Lock()
+	mm.siblingNetQty = siblingNetQty
+	mm.unbalanced = mm.hedgePolicy.Unbalanced(mm.exposure(), mm.unbalanced)
+	mm.rebalance()
+	mm.rwMutex.Unlock()
+}
+
+func (mm *MinisMarketMaker) exposure() float64 {
+	return mm.netQty + mm.siblingNetQty
+}
+
 ///////////////// Market Data Callbacks ////////////////////////////////
 func (mm *MinisMarketMaker) OnBookUpdated(bookUpdated marketdata.BookUpdated) {
 	//mm.logger.Printf("New Book Update of %v\n Bids: %+v\n Asks: %+v\n", bookUpdated.Security.Symbol, bookUpdated.Book.Bids, bookUpdated.Book.Asks)
@@ -230,7 +347,9 @@ func (mm *MinisMarketMaker) OnBookUpdated(bookUpdated marketdata.BookUpdated) {
 
 	if len(myBook) <= 0 || myBook[0].Qty <= 0 || myBook[0].Px <= 0 {
 		//myBook[0].Px = 0.0
+		mm.rwMutex.Lock()
 		mm.removeOrder()
+		mm.rwMutex.Unlock()
 		return
 	}
 
@@ -248,32 +367,64 @@ func (mm *MinisMarketMaker) OnBookUpdated(bookUpdated marketdata.BookUpdated) {
 	mm.rwMutex.Unlock()
 }
 
-func (mm *MinisMarketMaker) OnDisconnect(exchange security.Exchange)                   {}
-func (mm *MinisMarketMaker) OnSecurityStatus(securityStatus marketdata.SecurityStatus) {}
+func (mm *MinisMarketMaker) OnDisconnect(exchange security.Exchange) {
+	mm.logger.Printf("%v OnDisconnect %v, stop quoting until reconnect", mm.miniSecurity.Symbol, exchange)
+	mm.rwMutex.Lock()
+	mm.connected = false
+	mm.slot.MarkUncertain()
+	mm.rwMutex.Unlock()
+}
+
+func (mm *MinisMarketMaker) OnSecurityStatus(securityStatus marketdata.SecurityStatus) {
+	symbol := securityStatus.Security.Symbol
+	mm.rwMutex.Lock()
+	if symbol != mm.miniSecurity.Symbol && symbol != mm.stdSecurity.Symbol {
+		mm.rwMutex.Unlock()
+		return
+	}
+	if mm.phases.Update(securityStatus) {
+		mm.logger.Printf("%v trading phase %v", symbol, mm.phases.Phase(symbol))
+		mm.px = mm.calculatePx()
+		mm.qty = mm.calculateQty()
+		mm.rebalance()
+	}
+	mm.rwMutex.Unlock()
+}
 
 ///////////////// Market Maker Specific CallBacks ////////////////////////////////
 
 func (mm *MinisMarketMaker) calculatePx() float64 {
+	if mm.mktPx <= 0.0 {
+		return 0.0
+	}
 
+	spread := mm.vol
 	if mm.automaticSpreadEnabled {
-		if mm.side == order.Side_BUY {
-			return mm.mktPx - mm.automaticSpread
-		} else {
-			return mm.mktPx + mm.automaticSpread
-		}
+		spread += mm.automaticSpread
 	}
 
-	return mm.mktPx
+	skew := mm.inventorySkew.Offset(mm.netQty, mm.specs.TickSize(mm.miniSecurity))
+	px := mm.mktPx + spread - skew
+	if mm.side == order.Side_BUY {
+		px = mm.mktPx - spread - skew
+	}
+	return mm.specs.RoundPx(mm.miniSecurity, px, mm.side)
 }
 
-func (mm *MinisMarketMaker) calculateQty() float64 {
+func (mm *MinisMarketMaker) SetInventorySkew(inventorySkew InventorySkew) {
+	mm.rwMutex.Lock()
+	mm.inventorySkew = inventorySkew
+	mm.px = mm.calculatePx()
+	mm.rebalance()
+	mm.rwMutex.Unlock()
+}
 
-	return mm.qtyDefault
+func (mm *MinisMarketMaker) calculateQty() float64 {
+	return mm.params.Side(mm.miniSecurity.Symbol, mm.side).QtyFor(mm.side, mm.netQty)
 }
 
 func (mm *MinisMarketMaker) placeOrder() {
 	request := order.PlaceOrderRequest{
-		OrderId:  utils.UUID(),
 		Account:  mm.account,
 		Security: mm.miniSecurity,
 		Qty:      mm.qty,
@@ -282,110 +433,139 @@ func (mm *MinisMarketMaker) placeOrder() {
 		Type:     order.Type_LIMIT,
 		Validity: order.Validity_DAY,
 	}
-
-	newOrder, err := mm.broker.PlaceOrder(request, mm)
-	if err != nil {
-		//mm.logger.Printf("Cannot place new order: %+v. Error: %v", request, err)
-		return
-	}
-
-	mm.sentOrder = newOrder
+	mm.slot.Place(request)
 }
 
 func (mm *MinisMarketMaker) replaceOrder() {
-	request := order.ReplaceOrderRequest{
-		Order: *mm.activeOrder,
-		Qty:   mm.qty,
-		Px:    mm.px,
-	}
-
-	err := mm.broker.ReplaceOrder(request)
-	if err != nil {
-		mm.logger.Printf("Cannot replace order %+v with request: %+v. Error: %s", mm.activeOrder, request, err)
-		return
-	}
-
-	mm.sentOrder = mm.activeOrder
-	mm.sentOrder.Px = mm.px
-	mm.sentOrder.Qty = mm.qty
+	mm.slot.Replace(mm.px, mm.qty)
 }
 
 func (mm *MinisMarketMaker) rebalance() {
 
-	if mm.pendingCancel {
-		mm.logger.Printf("Rebalance, pendingCancel true")
+	if !mm.connected {
+		mm.logger.Printf("Cannot rebalance. Disconnected, waiting for reconnect")
+	} else if !mm.phases.Tradable(mm.miniSecurity.Symbol) {
+		mm.logger.Printf("Cannot rebalance. %v is in phase %v", mm.miniSecurity.Symbol, mm.phases.Phase(mm.miniSecurity.Symbol))
+		mm.removeOrder()
+	} else if !mm.phases.Tradable(mm.stdSecurity.Symbol) {
+		//sin el std no hay precio de referencia ni cobertura
+		mm.logger.Printf("Cannot rebalance. %v is in phase %v", mm.stdSecurity.Symbol, mm.phases.Phase(mm.stdSecurity.Symbol))
 		mm.removeOrder()
 	} else if mm.unbalanced {
 		mm.logger.Printf("Rebalance, position unbalanced, waiting for balancer")
-
+		mm.removeOrder()
 	} else if !mm.enabledAll {
 		mm.logger.Printf("Cannot rebalance. Robot is disabled for all assets.")
 		mm.removeOrder()
+	} else if mm.stopped {
+		mm.logger.Printf("Cannot rebalance. Quoting stopped until resumed")
+		mm.removeOrder()
 	} else if !mm.enabled {
 		mm.logger.Printf("Cannot rebalance. Robot is disabled.")
 
 	} else if mm.px <= 0.0 {
 		mm.logger.Println("Cannot rebalance. Px <= 0")
 		mm.removeOrder()
-	} else if mm.sentOrder != nil {
+	} else if mm.slot.Pending() {
 		mm.logger.Println("Cannot rebalance. Another action is pending acknowledgement")
-	} else if mm.activeOrder == nil && mm.sentOrder == nil {
-
+	} else if mm.slot.State() == SLOT_CANCEL_REJECTED {
+		mm.logger.Println("Cannot rebalance. Cancel was rejected, waiting for order state")
+	} else if mm.slot.Idle() {
+		if mm.slot.Rejected() {
+			mm.logger.Println("Cannot rebalance. Too many rejected orders")
+			return
+		}
 		mm.placeOrder()
-	} else if mm.activeOrder.Px != mm.px || (mm.activeOrder.Qty-mm.activeOrder.CumQty) != mm.qty {
+	} else if mm.slot.Active().Px != mm.px || mm.slot.Leaves() != mm.qty {
+		mm.logger.Printf("active order: %+v\n MM Px: %+v\n MM qty: %v\n", mm.slot.Active(), mm.px, mm.qty)
 		mm.replaceOrder()
-		mm.logger.Printf("active order: %+v\n MM Px: %+v\n MM qty: %v\n", mm.activeOrder, mm.px, mm.qty)
 	}
 }
 
-func (mm *MinisMarketMaker) cancelOrder() {
-	mm.logger.Printf("CancelOrder has been called")
-	request := order.CancelOrderRequest{
-		Order: *mm.activeOrder,
-	}
-	err := mm.broker.CancelOrder(request)
-	if err != nil {
-		mm.logger.Printf("Cannot cancel order %+v. Error %v", mm.activeOrder, err)
-	}
-	mm.sentOrder = mm.activeOrder
+func (mm *MinisMarketMaker) removeOrder() {
+	mm.slot.Cancel()
 }
 
-func (mm *MinisMarketMaker) invalidEvent(event order.OrderEvent) bool {
-	invalidOrder := (mm.activeOrder == nil && mm.sentOrder == nil)
-	invalidOrder = invalidOrder ||
-		((mm.activeOrder != nil && mm.activeOrder.Id != event.Order.Id) && (mm.sentOrder != nil && mm.sentOrder.Id != event.Order.Id))
-	if invalidOrder {
-		mm.logger.Printf("Received order event for an unknown order: %+v", event)
+func (mm *MinisMarketMaker) CheckPendingAck(now time.Time, timeout time.Duration) (string, bool) {
+	mm.rwMutex.Lock()
+	defer mm.rwMutex.Unlock()
+	if !mm.connected {
+		return "", false
 	}
-	return invalidOrder
-}
-
-func (mm *MinisMarketMaker) removeOrder() {
-	if mm.activeOrder != nil {
-		if mm.sentOrder != nil {
-			mm.pendingCancel = true
-		} else if !mm.cancelRejected {
-			mm.cancelOrder()
-		}
+	msg, stuck := mm.slot.CheckPendingAck(now, timeout)
+	if stuck {
+		msg = mm.miniSecurity.Symbol + " market maker: " + msg
 	}
+	return msg, stuck
 }
 
 func (mm *MinisMarketMaker) OnSyntheticPositionChange(syntheticInstrument string, event position.PositionEvent) {
 	mm.logger.Printf("Synthetic Position %s: %+v\n", syntheticInstrument, event)
 	mm.rwMutex.Lock()
-	mm.unbalanced = false
-	if event.NewPosition.NetQty >= 60 || event.NewPosition.NetQty <= -60 {
-		mm.unbalanced = true
+	//la historica incluye la del dia, se cotiza sobre la exposicion total
+	mm.netQty = event.NewHistoricalPosition.NetQty
+	mm.unbalanced = mm.hedgePolicy.Unbalanced(mm.exposure(), mm.unbalanced)
+	mm.px = mm.calculatePx()
+	mm.qty = mm.calculateQty()
+	mm.rebalance()
+	mm.rwMutex.Unlock()
+}
+
+// settings callbacks ///
+
+func (mm *MinisMarketMaker) OnBotSettingChange(botSetting settings.BotSetting) {
+	mm.logger.Printf("%v OnBotSettingChange %+v\n", mm.miniSecurity.Symbol, botSetting)
+	if err := ValidateBotSetting(botSetting); err != nil {
+		mm.logger.Printf("Rejected bot setting %+v: %v", botSetting, err)
+		return
+	}
+
+	mm.rwMutex.Lock()
+	applied, err := mm.applyBotSetting(botSetting)
+	if err != nil {
+		mm.logger.Printf("Invalid bot setting %+v: %v", botSetting, err)
+	} else if applied {
+		mm.unbalanced = mm.hedgePolicy.Unbalanced(mm.exposure(), mm.unbalanced)
 		mm.px = mm.calculatePx()
 		mm.qty = mm.calculateQty()
 		mm.rebalance()
 	}
+	settingsManager := mm.settingsManager
 	mm.rwMutex.Unlock()
+
+	//los dos market makers aplican el setting, el eco lo manda solo el bid
+	if applied && err == nil && settingsManager != nil && mm.side == order.Side_BUY {
+		settingsManager.ChangeBotSetting(botSetting.Key, botSetting.Value)
+	}
 }
 
-// settings callbacks ///
+// OnHedgePolicyChange llega cuando el balancer aplica un setting HEDGE_*.
+func (mm *MinisMarketMaker) OnHedgePolicyChange() {
+	mm.rwMutex.Lock()
+	mm.unbalanced = mm.hedgePolicy.Unbalanced(mm.exposure(), mm.unbalanced)
+	mm.px = mm.calculatePx()
+	mm.qty = mm.calculateQty()
+	mm.rebalance()
+	mm.rwMutex.Unlock()
+}
 
-func (mm *MinisMarketMaker) OnBotSettingChange(botSetting settings.BotSetting) {} //chequear
+// applyBotSetting pasa el setting por la cantidad de la cotizacion y el modelo
+// de spread, en ese orden. La politica de cobertura la aplica el balancer.
+func (mm *MinisMarketMaker) applyBotSetting(botSetting settings.BotSetting) (bool, error) {
+	if botSetting.Key == QUOTE_QTY {
+		return true, mm.params.SetQty(mm.miniSecurity.Symbol, mm.side, botSetting.Value)
+	}
+	if applied, err := mm.inventorySkew.ApplyBotSetting(botSetting); applied {
+		return applied, err
+	}
+	if spreadModel, ok := mm.spreadModel.(BotSettingApplier); ok {
+		return spreadModel.ApplyBotSetting(botSetting)
+	}
+	if isSpreadSetting(botSetting.Key) {
+		return true, fmt.Errorf("spread model %T does not take spread settings", mm.spreadModel)
+	}
+	return false, nil
+}
 
 func (mm *MinisMarketMaker) OnBotEnabledChange(botEnabled settings.Enabled) {
 	mm.logger.Printf("%v OnBotEnabledChange %+v\n", mm.miniSecurity.Symbol, botEnabled)
@@ -407,7 +587,91 @@ func (mm *MinisMarketMaker) OnBotEnabledChange(botEnabled settings.Enabled) {
 	mm.rwMutex.Unlock()
 }
 
-func (mm *MinisMarketMaker) OnCommand(command settings.FrontCommand) {}
+func (mm *MinisMarketMaker) OnCommand(command settings.FrontCommand) {
+	if !commandFor(command, mm.miniSecurity.Symbol) {
+		return
+	}
+	mm.logger.Printf("%v %v OnCommand %+v\n", mm.miniSecurity.Symbol, mm.side, command)
+
+	mm.rwMutex.Lock()
+	msg := ""
+	switch command.Command {
+	case CMD_CANCEL_QUOTES:
+		mm.deactivate("asset")
+		msg = "quotes cancelled"
+	case CMD_FLATTEN:
+		//en un flatten el market maker deja de cotizar y el balancer cierra
+		mm.stopped = true
+		mm.removeOrder()
+		msg = "quoting stopped"
+	case CMD_PAUSE_BID, CMD_PAUSE_ASK:
+		if !mm.commandSide(command) {
+			break
+		}
+		mm.deactivate("asset")
+		msg = "paused"
+	case CMD_RESUME_BID, CMD_RESUME_ASK:
+		if !mm.commandSide(command) {
+			break
+		}
+		mm.enabled = true
+		mm.stopped = false
+		mm.notifyAssetState(1)
+		mm.px = mm.calculatePx()
+		mm.qty = mm.calculateQty()
+		mm.rebalance()
+		msg = "resumed"
+	case CMD_RESET_STATE:
+		mm.slot.Reset()
+		mm.px = mm.calculatePx()
+		mm.qty = mm.calculateQty()
+		mm.rebalance()
+		msg = "state reset"
+	case CMD_DUMP_STATUS:
+		msg = mm.status()
+	case CMD_FORCE_HEDGE, CMD_DUMP_PNL:
+		//lo atienden el balancer y el PnLEngine
+	default:
+		mm.logger.Printf("%v Command not recognized: %+v", mm.miniSecurity.Symbol, command)
+	}
+	mm.rwMutex.Unlock()
+
+	if msg != "" {
+		mm.ack(command, msg)
+	}
+}
+
+func (mm *MinisMarketMaker) commandSide(command settings.FrontCommand) bool {
+	if mm.side == order.Side_BUY {
+		return command.Command == CMD_PAUSE_BID || command.Command == CMD_RESUME_BID
+	}
+	return command.Command == CMD_PAUSE_ASK || command.Command == CMD_RESUME_ASK
+}
+
+func (mm *MinisMarketMaker) status() string {
+	return fmt.Sprintf("enabled: %v enabledAll: %v stopped: %v connected: %v unbalanced: %v netQty: %v siblingNetQty: %v px: %v qty: %v slot: %v order: %+v",
+		mm.enabled, mm.enabledAll, mm.stopped, mm.connected, mm.unbalanced, mm.netQty, mm.siblingNetQty, mm.px, mm.qty, mm.slot.State(), mm.slot.Order())
+}
+
+func (mm *MinisMarketMaker) ack(command settings.FrontCommand, msg string) {
+	ack := fmt.Sprintf("%v %v %v: %v", mm.miniSecurity.Symbol, mm.side, command.Command, msg)
+	mm.logger.Printf("%s", ack)
+	if mm.traderUpdater != nil {
+		mm.traderUpdater.SendToast(ack)
+	}
+}
+
+func (mm *MinisMarketMaker) SetSettingsManager(settingsManager *settings.SettingsManager) {
+	mm.rwMutex.Lock()
+	mm.settingsManager = settingsManager
+	mm.rwMutex.Unlock()
+}
+
+func (mm *MinisMarketMaker) SetTraderUpdater(traderUpdater TraderUpdater) {
+	mm.rwMutex.Lock()
+	mm.traderUpdater = traderUpdater
+	mm.rwMutex.Unlock()
+}
 
 func (mm *MinisMarketMaker) OnAssetSettingChange(assetSetting settings.AssetSetting) {
 	if assetSetting.Asset != mm.miniSecurity.Symbol {
@@ -432,40 +696,25 @@ func (mm *MinisMarketMaker) OnAssetSettingChange(assetSetting settings.AssetSett
 	case settings.CHANGE_VOL_BID:
 		mm.logger.Printf("%+v Change vol bid: %+v", &mm.miniSecurity.Symbol, assetSetting)
 		if mm.side == order.Side_BUY {
-			if assetSetting.Value >= 0.0 {
-				rebalance = true
-				mm.rwMutex.Unlock()
-			}
+			rebalance = mm.changeVol(assetSetting.Value)
 		}
 
 	case settings.CHANGE_VOL_ASK:
 		mm.logger.Printf("%+v Change vol ask: %+v", &mm.miniSecurity.Symbol, assetSetting)
 		if mm.side == order.Side_SELL {
-			if assetSetting.Value >= 0.0 {
-				rebalance = true
-			}
+			rebalance = mm.changeVol(assetSetting.Value)
 		}
 
 	case settings.CHANGE_QTY_BID:
 		mm.logger.Printf("%+v Change qty bid: %+v", &mm.miniSecurity.Symbol, assetSetting)
 		if mm.side == order.Side_BUY {
-			if assetSetting.Value >= 0.0 {
-				mm.rwMutex.Lock()
-				mm.qty = assetSetting.Value
-				rebalance = true
-				mm.rwMutex.Unlock()
-			}
+			rebalance = mm.changeQty(assetSetting.Value)
 		}
 
 	case settings.CHANGE_QTY_ASK:
 		mm.logger.Printf("%+v Change qty ask: %+v", &mm.miniSecurity.Symbol, assetSetting)
 		if mm.side == order.Side_SELL {
-			if assetSetting.Value >= 0.0 {
-				mm.rwMutex.Lock()
-				mm.qty = assetSetting.Value
-				rebalance = true
-				mm.rwMutex.Unlock()
-			}
+			rebalance = mm.changeQty(assetSetting.Value)
 		}
 	default:
 		mm.logger.Printf("%+v Asset setting key not recognized: %+v", &mm.miniSecurity.Symbol, assetSetting)
@@ -483,28 +732,62 @@ func (mm *MinisMarketMaker) OnAssetSettingChange(assetSetting settings.AssetSett
 
 }
 
+func (mm *MinisMarketMaker) changeVol(vol float64) bool {
+	err := mm.params.SetVol(mm.miniSecurity.Symbol, mm.side, vol)
+	if err != nil {
+		mm.logger.Printf("%+v Invalid vol %v for side %v: %v", mm.miniSecurity.Symbol, vol, mm.side, err)
+		return false
+	}
+
+	mm.rwMutex.Lock()
+	mm.vol = vol
+	mm.rwMutex.Unlock()
+	return true
+}
+
+func (mm *MinisMarketMaker) changeQty(qty float64) bool {
+	err := mm.params.SetQty(mm.miniSecurity.Symbol, mm.side, qty)
+	if err != nil {
+		mm.logger.Printf("%+v Invalid qty %v for side %v: %v", mm.miniSecurity.Symbol, qty, mm.side, err)
+		return false
+	}
+	return true
+}
+
 func (mm *MinisMarketMaker) deactivate(notify string) {
 	if mm.enabled {
 		switch notify {
 		case "asset":
 			mm.enabled = false
-			if mm.side == order.Side_SELL {
-				mm.settingsManager.ChangeAssetState(settings.SWITCH_ASSET_ASK, 0, mm.miniSecurity.Symbol)
-			} else {
-				mm.settingsManager.ChangeAssetState(settings.SWITCH_ASSET_BID, 0, mm.miniSecurity.Symbol)
-			}
+			mm.notifyAssetState(0)
 
 		case "global":
 			mm.enabledAll = false
-			mm.settingsManager.ChangeRobotState(0)
+			if mm.settingsManager != nil {
+				mm.settingsManager.ChangeRobotState(0)
+			}
 		default:
-			mm.logger.Printf(notify)
-			//mm.traderUpdater.SendToast(notify)
+			mm.logger.Printf("%s", notify)
+			if mm.traderUpdater != nil {
+				mm.traderUpdater.SendToast(notify)
+			}
 		}
 	}
 	mm.removeOrder()
 }
 
+// notifyAssetState avisa al front el estado del lado que cotiza esta instancia.
+func (mm *MinisMarketMaker) notifyAssetState(value float64) {
+	if mm.settingsManager == nil {
+		return
+	}
+	if mm.side == order.Side_SELL {
+		mm.settingsManager.ChangeAssetState(settings.SWITCH_ASSET_ASK, value, mm.miniSecurity.Symbol)
+	} else {
+		mm.settingsManager.ChangeAssetState(settings.SWITCH_ASSET_BID, value, mm.miniSecurity.Symbol)
+	}
+}
+
 func (mm *MinisMarketMaker) switchState(assetSetting settings.AssetSetting) {
 	if mm.enabled {
 		if assetSetting.Value == 0 {
@@ -524,24 +807,5 @@ func (mm *MinisMarketMaker) switchState(assetSetting settings.AssetSetting) {
 }
 
 func (mm *MinisMarketMaker) calculateSpread(bookUpdated marketdata.BookUpdated) float64 {
-	//evaluar escenario donde solo hay una punta (colocar spread de 0,5)
-
-	spread := 0.0
-	if bookUpdated.Book.Asks[0].Px == 0.0 && bookUpdated.Book.Bids[0].Px == 0.0 {
-		return spread
-	}
-
-	if bookUpdated.Book.Asks[0].Px == 0.0 || bookUpdated.Book.Bids[0].Px == 0.0 {
-		spread = 0.5
-		return spread
-	}
-
-	futureSpread := bookUpdated.Book.Asks[0].Px - bookUpdated.Book.Bids[0].Px
-	if futureSpread > 1 {
-		spread = 0.3
-	} else if futureSpread < 0.9 {
-		spread = 0.1
-	}
-
-	return spread
+	return mm.spreadModel.Spread(bookUpdated.Book)
 }
@@ -0,0 +1,110 @@
+package minis
+
+import (
+	"testing"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/components-support/settings"
+)
+
+func newTestMarketMaker(t *testing.T, side order.Side) (*MinisMarketMaker, *fakeBroker) {
+	t.Helper()
+	fb := &fakeBroker{}
+	mm := NewMinisMarketMaker(testMini, side, "account", fb, nil, nil, nil, nil)
+	mm.slot.SetClock(newFakeClock())
+	return mm, fb
+}
+
+func TestMarketMakerStopsQuotingWhenStdHalts(t *testing.T) {
+	mm, fb := newTestMarketMaker(t, order.Side_BUY)
+	if mm.stdSecurity.Symbol != testStd.Symbol {
+		t.Fatalf("stdSecurity = %q, want %q", mm.stdSecurity.Symbol, testStd.Symbol)
+	}
+
+	mm.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+	if len(fb.places) != 1 {
+		t.Fatalf("places = %+v, want one quote", fb.places)
+	}
+	mm.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+
+	mm.OnSecurityStatus(marketdata.SecurityStatus{Security: testStd, Status: 2})
+	if len(fb.cancels) != 1 {
+		t.Fatalf("cancels = %+v, want the quote pulled while the std is halted", fb.cancels)
+	}
+	mm.OnOrderCancelled(order.OrderCancelled{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+
+	mm.OnSecurityStatus(marketdata.SecurityStatus{Security: testStd, Status: 17})
+	if len(fb.places) != 2 {
+		t.Fatalf("places = %d, want quoting again once the std trades", len(fb.places))
+	}
+}
+
+func TestMarketMakerFlattenStopsUntilResume(t *testing.T) {
+	mm, fb := newTestMarketMaker(t, order.Side_SELL)
+	mm.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+	mm.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+
+	mm.OnCommand(settings.FrontCommand{Command: CMD_FLATTEN})
+	if len(fb.cancels) != 1 || !mm.enabled {
+		t.Fatalf("cancels = %+v enabled = %v, want the quote pulled without disabling the asset", fb.cancels, mm.enabled)
+	}
+	mm.OnOrderCancelled(order.OrderCancelled{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+	mm.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+	if len(fb.places) != 1 {
+		t.Fatalf("quoting while stopped: places = %+v", fb.places)
+	}
+
+	mm.OnCommand(settings.FrontCommand{Command: CMD_RESUME_BID})
+	if len(fb.places) != 1 {
+		t.Fatalf("the bid resume restarted the ask: places = %+v", fb.places)
+	}
+	mm.OnCommand(settings.FrontCommand{Command: CMD_RESUME_ASK})
+	if len(fb.places) != 2 {
+		t.Fatalf("places = %d after %v, want quoting again", len(fb.places), CMD_RESUME_ASK)
+	}
+}
+
+func TestHedgePolicyAppliedByBalancerOnly(t *testing.T) {
+	hedgePolicy := NewDefaultHedgePolicy()
+	fb := &fakeBroker{}
+	mm := NewMinisMarketMaker(testMini, order.Side_BUY, "account", fb, nil, nil, testSpecs(), hedgePolicy)
+	mm.slot.SetClock(newFakeClock())
+	b := NewBalancer(testStd, testMini, "account", &fakeBroker{}, testSpecs(), hedgePolicy, nil)
+	b.SetClock(newFakeClock())
+
+	mm.OnSyntheticPositionChange("net", netQtyEvent(50))
+	mm.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+	if len(fb.places) != 1 {
+		t.Fatalf("places = %+v, want one quote", fb.places)
+	}
+	mm.OnOrderPlaced(order.OrderPlaced{OrderEvent: order.OrderEvent{Order: fb.lastOrder()}})
+
+	//el market maker ignora las claves de la politica
+	mm.OnBotSettingChange(settings.BotSetting{Key: HEDGE_TRIGGER, Value: 45})
+	if trigger, _, _ := hedgePolicy.Get(); trigger != 60 {
+		t.Fatalf("trigger = %v after the market maker setting, want 60", trigger)
+	}
+
+	//el balancer la aplica y el market maker se entera
+	b.OnBotSettingChange(settings.BotSetting{Key: HEDGE_TRIGGER, Value: 45})
+	if trigger, _, _ := hedgePolicy.Get(); trigger != 45 {
+		t.Fatalf("trigger = %v, want 45", trigger)
+	}
+	if len(fb.cancels) != 1 {
+		t.Fatalf("cancels = %+v, want the quote pulled once unbalanced", fb.cancels)
+	}
+}
+
+func TestMarketMakerRejectsSpreadSettingsWithoutModel(t *testing.T) {
+	mm, _ := newTestMarketMaker(t, order.Side_BUY)
+	applied, err := mm.applyBotSetting(settings.BotSetting{Key: SPREAD_TIER_1, Value: 0.2})
+	if !applied || err == nil {
+		t.Fatalf("applyBotSetting = %v, %v, want the spread setting rejected", applied, err)
+	}
+
+	mm = NewMinisMarketMaker(testMini, order.Side_BUY, "account", &fakeBroker{}, NewTieredSpreadModel(nil, 0.5), nil, nil, nil)
+	if applied, err := mm.applyBotSetting(settings.BotSetting{Key: SPREAD_TIER_1, Value: 0.2}); !applied || err != nil {
+		t.Fatalf("applyBotSetting = %v, %v with a tiered model", applied, err)
+	}
+}
@@ -0,0 +1,254 @@
+package minis
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/position"
+)
+
+// Leg es una pata de una posicion sintetica: las ejecuciones en Symbol se
+// suman con signo Sign y Multiplier toneladas por contrato.
+type Leg struct {
+	Symbol     string
+	Sign       float64
+	Multiplier float64
+}
+
+// NetFutureLegs suma el std y el mini del mismo vencimiento.
+func NetFutureLegs(stdSymbol string, miniSymbol string) []Leg {
+	return []Leg{
+		{Symbol: stdSymbol, Sign: 1},
+		{Symbol: miniSymbol, Sign: 1},
+	}
+}
+
+// SpreadLegs compra near y vende far: pase entre meses del mismo producto o
+// entre productos distintos.
+func SpreadLegs(nearSymbol string, farSymbol string) []Leg {
+	return []Leg{
+		{Symbol: nearSymbol, Sign: 1},
+		{Symbol: farSymbol, Sign: -1},
+	}
+}
+
+// NetFuturePosition es una posicion sintetica en toneladas armada con una
+// tabla de patas. La posicion del dia y la historica (la que viene de dias
+// anteriores) se llevan por separado; Position las suma. La del robot, std
+// mas mini, se arma con NetFutureLegs y el nombre mini-NET_FUTURE_POSITION.
+type NetFuturePosition struct {
+	mutex            sync.Mutex
+	netFutureSymbol  string
+	signs            map[string]float64
+	multipliers      map[string]float64
+	products         map[string]string
+	position         position.Position
+	historical       position.Position
+	historicalLoaded bool
+	pnl              *PnLEngine
+}
+
+// NewNetFuturePosition toma de specs las toneladas por contrato de las patas
+// que no las traen; sin spec usa el tamano por defecto del mercado de la pata.
+func NewNetFuturePosition(netFutureSymbol string, legs []Leg, specs *ContractSpecs) (*NetFuturePosition, error) {
+	if specs == nil {
+		specs = NewContractSpecs()
+	}
+	if len(legs) == 0 {
+		return nil, fmt.Errorf("synthetic %s has no legs", netFutureSymbol)
+	}
+
+	nfp := &NetFuturePosition{
+		netFutureSymbol: netFutureSymbol,
+		signs:           make(map[string]float64),
+		multipliers:     make(map[string]float64),
+		products:        make(map[string]string),
+	}
+	for _, leg := range legs {
+		if _, ok := nfp.signs[leg.Symbol]; ok {
+			return nil, fmt.Errorf("synthetic %s has leg %s twice", netFutureSymbol, leg.Symbol)
+		}
+		if leg.Sign == 0.0 {
+			return nil, fmt.Errorf("synthetic %s leg %s has no sign", netFutureSymbol, leg.Symbol)
+		}
+		multiplier := leg.Multiplier
+		if multiplier <= 0.0 {
+			multiplier = specs.TonsPerContract(security.Security{Symbol: leg.Symbol})
+		}
+
+		nfp.signs[leg.Symbol] = leg.Sign
+		nfp.multipliers[leg.Symbol] = multiplier
+		nfp.products[leg.Symbol] = leg.Symbol
+		if ticker, err := ParseTicker(leg.Symbol); err == nil {
+			nfp.products[leg.Symbol] = ticker.Product
+		}
+	}
+	return nfp, nil
+}
+
+func (nfp *NetFuturePosition) Symbol() string {
+	return nfp.netFutureSymbol
+}
+
+// Products son los productos de las patas, uno solo salvo en un pase entre
+// productos.
+func (nfp *NetFuturePosition) Products() []string {
+	products := make([]string, 0, len(nfp.products))
+	seen := make(map[string]bool)
+	for _, product := range nfp.products {
+		if !seen[product] {
+			seen[product] = true
+			products = append(products, product)
+		}
+	}
+	sort.Strings(products)
+	return products
+}
+
+// Position es la posicion total: la historica mas la del dia.
+func (nfp *NetFuturePosition) Position() position.Position {
+	nfp.mutex.Lock()
+	defer nfp.mutex.Unlock()
+	return addPositions(nfp.historical, nfp.position)
+}
+
+func (nfp *NetFuturePosition) Intraday() position.Position {
+	nfp.mutex.Lock()
+	defer nfp.mutex.Unlock()
+	return nfp.position
+}
+
+func (nfp *NetFuturePosition) Historical() position.Position {
+	nfp.mutex.Lock()
+	defer nfp.mutex.Unlock()
+	return nfp.historical
+}
+
+// SetHistorical fija la posicion historica de la sintetica, en toneladas.
+func (nfp *NetFuturePosition) SetHistorical(historical position.Position) {
+	nfp.mutex.Lock()
+	nfp.setHistorical(historical)
+	nfp.mutex.Unlock()
+}
+
+func (nfp *NetFuturePosition) setHistorical(historical position.Position) {
+	nfp.historical = historical
+	nfp.historicalLoaded = true
+}
+
+// LoadHistorical arma la posicion historica de la sintetica con las
+// posiciones historicas por instrumento, en contratos, y devuelve el evento
+// que la publica.
+func (nfp *NetFuturePosition) LoadHistorical(historicalSecurityPositions map[string]position.Position) position.PositionEvent {
+	nfp.mutex.Lock()
+	defer nfp.mutex.Unlock()
+
+	oldTotal := addPositions(nfp.historical, nfp.position)
+	nfp.setHistorical(nfp.fromLegs(historicalSecurityPositions))
+	return startupEvent(nfp.position, oldTotal, addPositions(nfp.historical, nfp.position))
+}
+
+// Resync toma las posiciones totales de las patas, en contratos, y deja como
+// posicion del dia lo que no es historica.
+func (nfp *NetFuturePosition) Resync(securityPositions map[string]position.Position) {
+	nfp.mutex.Lock()
+	defer nfp.mutex.Unlock()
+	nfp.position = subtractPositions(nfp.fromLegs(securityPositions), nfp.historical)
+	nfp.historicalLoaded = true
+}
+
+func (nfp *NetFuturePosition) fromLegs(securityPositions map[string]position.Position) position.Position {
+	p := position.Position{}
+	for symbol, securityPosition := range securityPositions {
+		if _, ok := nfp.signs[symbol]; !ok {
+			continue
+		}
+		nfp.add(&p, symbol, order.Side_BUY, securityPosition.BuyQty, securityPosition.AvgBuyPx, 1)
+		nfp.add(&p, symbol, order.Side_SELL, securityPosition.SellQty, securityPosition.AvgSellPx, 1)
+	}
+	return p
+}
+
+func (nfp *NetFuturePosition) ConsumeExecution(
+	orderEvent order.OrderEvent,
+	securityPositions map[string]position.Position,
+	syntheticPositions map[string]position.Position,
+	historicalSecurityPositions map[string]position.Position,
+	historicalSyntheticPositions map[string]position.Position,
+) *position.PositionEvent {
+	//la historica se carga con el mismo lock que el fill, asi no se pisa con la del arranque
+	nfp.mutex.Lock()
+	if !nfp.historicalLoaded {
+		if historical, ok := historicalSyntheticPositions[nfp.netFutureSymbol]; ok {
+			nfp.setHistorical(historical)
+		} else if len(historicalSecurityPositions) > 0 {
+			nfp.setHistorical(nfp.fromLegs(historicalSecurityPositions))
+		}
+	}
+	event := nfp.apply(orderEvent, 1)
+	nfp.mutex.Unlock()
+	if pnl := nfp.pnlEngine(); event != nil && pnl != nil {
+		pnl.OnExecution(orderEvent)
+	}
+	return event
+}
+
+// SetPnLEngine hace que cada ejecucion de las patas se registre en pnl.
+func (nfp *NetFuturePosition) SetPnLEngine(pnl *PnLEngine) {
+	nfp.mutex.Lock()
+	nfp.pnl = pnl
+	nfp.mutex.Unlock()
+}
+
+func (nfp *NetFuturePosition) pnlEngine() *PnLEngine {
+	nfp.mutex.Lock()
+	defer nfp.mutex.Unlock()
+	return nfp.pnl
+}
+
+// ReverseExecution deshace una ejecucion cancelada por el mercado. Devuelve
+// nil si el trade no es de ninguna pata.
+func (nfp *NetFuturePosition) ReverseExecution(orderEvent order.OrderEvent) *position.PositionEvent {
+	nfp.mutex.Lock()
+	event := nfp.apply(orderEvent, -1)
+	nfp.mutex.Unlock()
+	if pnl := nfp.pnlEngine(); event != nil && pnl != nil {
+		pnl.Reverse(orderEvent)
+	}
+	return event
+}
+
+// apply se llama con el lock tomado.
+func (nfp *NetFuturePosition) apply(orderEvent order.OrderEvent, sign float64) *position.PositionEvent {
+	symbol := orderEvent.Order.Security.Symbol
+	if _, ok := nfp.signs[symbol]; !ok {
+		return nil
+	}
+
+	oldPosition := nfp.position
+	nfp.add(&nfp.position, symbol, orderEvent.ExecutionReport.Side, orderEvent.ExecutionReport.Qty, orderEvent.ExecutionReport.Px, sign)
+	return &position.PositionEvent{
+		OldPosition:           oldPosition,
+		NewPosition:           nfp.position,
+		OldHistoricalPosition: addPositions(nfp.historical, oldPosition),
+		NewHistoricalPosition: addPositions(nfp.historical, nfp.position),
+	}
+}
+
+// add suma qty contratos de symbol en side a px a p. Una compra en una pata
+// con signo negativo es una venta de la sintetica.
+func (nfp *NetFuturePosition) add(p *position.Position, symbol string, side order.Side, qty float64, px float64, sign float64) {
+	legSign := nfp.signs[symbol]
+	if legSign < 0 {
+		legSign = -legSign
+		if side == order.Side_BUY {
+			side = order.Side_SELL
+		} else {
+			side = order.Side_BUY
+		}
+	}
+	addTons(p, side, sign*legSign*qty*nfp.multipliers[symbol], px)
+}
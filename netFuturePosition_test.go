@@ -0,0 +1,137 @@
+package minis
+
+import (
+	"testing"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/position"
+)
+
+func legFill(symbol string, side order.Side, qty float64, px float64) order.OrderEvent {
+	return order.OrderEvent{
+		Order:           order.Order{Security: security.Security{Symbol: symbol}, Side: side, Qty: qty},
+		ExecutionReport: order.ExecutionReport{Side: side, Qty: qty, Px: px},
+	}
+}
+
+func TestNetFuturePositionLegs(t *testing.T) {
+	tests := []struct {
+		name   string
+		legs   []Leg
+		fills  []order.OrderEvent
+		netQty float64
+	}{
+		{"net future without specs", NetFutureLegs(testStd.Symbol, testMini.Symbol), []order.OrderEvent{
+			legFill(testStd.Symbol, order.Side_BUY, 1, 300),
+			legFill(testMini.Symbol, order.Side_SELL, 3, 301),
+		}, 70},
+		{"cross month spread", SpreadLegs("SOJ.ROS/MAY24", "SOJ.ROS/JUL24"), []order.OrderEvent{
+			legFill("SOJ.ROS/MAY24", order.Side_BUY, 2, 300),
+			legFill("SOJ.ROS/JUL24", order.Side_BUY, 2, 305),
+		}, 0},
+		{"foreign symbol", NetFutureLegs(testStd.Symbol, testMini.Symbol), []order.OrderEvent{
+			legFill("TRI.ROS/MAY24", order.Side_BUY, 1, 200),
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nfp, err := NewNetFuturePosition("net", tt.legs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, fill := range tt.fills {
+				nfp.ConsumeExecution(fill, nil, nil, nil, nil)
+			}
+			if netQty := nfp.Position().NetQty; netQty != tt.netQty {
+				t.Fatalf("net qty = %v, want %v", netQty, tt.netQty)
+			}
+		})
+	}
+}
+
+func TestNetFuturePositionHistorical(t *testing.T) {
+	nfp, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), testSpecs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	historical := map[string]position.Position{
+		testStd.Symbol:  {BuyQty: 2, AvgBuyPx: 300},
+		testMini.Symbol: {SellQty: 5, AvgSellPx: 302},
+	}
+	nfp.ConsumeExecution(legFill(testMini.Symbol, order.Side_BUY, 1, 301), nil, nil, historical, nil)
+	if nfp.Historical().NetQty != 150 || nfp.Intraday().NetQty != 10 || nfp.Position().NetQty != 160 {
+		t.Fatalf("historical %v intraday %v total %v, want 150, 10, 160",
+			nfp.Historical().NetQty, nfp.Intraday().NetQty, nfp.Position().NetQty)
+	}
+}
+
+func TestFillPriceFromExecutionReport(t *testing.T) {
+	specs := testSpecs()
+	nfp, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tons := NewTonsPosition(testMini, position.Position{}, specs)
+	pnl := NewPnLEngine(testStd, testMini, specs, newFakeClock())
+	nfp.SetPnLEngine(pnl)
+
+	//el precio de la orden y el del evento no son el del fill
+	fill := legFill(testMini.Symbol, order.Side_BUY, 2, 301)
+	fill.Order.Px = 305
+	fill.Px = 305
+	nfp.ConsumeExecution(fill, nil, nil, nil, nil)
+	tons.ConsumeExecution(fill, nil, nil, nil, nil)
+
+	if px := nfp.Position().AvgBuyPx; px != 301 {
+		t.Errorf("net future avg buy px = %v, want 301", px)
+	}
+	if px := tons.Position().AvgBuyPx; px != 301 {
+		t.Errorf("tons position avg buy px = %v, want 301", px)
+	}
+	if combined := pnl.Snapshot().Combined; combined.AvgPx != 301 || combined.NetQty != 20 {
+		t.Errorf("pnl combined = %+v, want 20 tons @ 301", combined)
+	}
+}
+
+func TestNetFuturePositionResync(t *testing.T) {
+	nfp, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfp.LoadHistorical(map[string]position.Position{testStd.Symbol: {BuyQty: 1, AvgBuyPx: 300}})
+
+	//mientras estuvo desconectado se compraron 2 std a 315
+	nfp.Resync(map[string]position.Position{testStd.Symbol: {BuyQty: 3, AvgBuyPx: 310}})
+	if total := nfp.Position(); total.NetQty != 300 || total.AvgBuyPx != 310 {
+		t.Fatalf("total = %+v, want 300 tons at 310", total)
+	}
+	if intraday := nfp.Intraday(); intraday.BuyQty != 200 || intraday.AvgBuyPx != 315 {
+		t.Fatalf("intraday = %+v, want 200 tons at 315", intraday)
+	}
+}
+
+func TestLoadHistoricalEventStartsFromPreviousTotal(t *testing.T) {
+	nfp, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tons := NewTonsPosition(testStd, position.Position{}, nil)
+	//el fill llega antes que la historica del arranque
+	fill := legFill(testStd.Symbol, order.Side_BUY, 1, 300)
+	nfp.ConsumeExecution(fill, nil, nil, nil, nil)
+	tons.ConsumeExecution(fill, nil, nil, nil, nil)
+
+	historical := map[string]position.Position{testStd.Symbol: {BuyQty: 2, NetQty: 2, AvgBuyPx: 290}}
+	for name, event := range map[string]position.PositionEvent{
+		"net future": nfp.LoadHistorical(historical),
+		"tons":       tons.LoadHistorical(historical),
+	} {
+		if event.OldPosition.NetQty != 100 || event.NewPosition.NetQty != 100 {
+			t.Errorf("%s intraday = %v -> %v, want 100 unchanged", name, event.OldPosition.NetQty, event.NewPosition.NetQty)
+		}
+		if event.OldHistoricalPosition.NetQty != 100 || event.NewHistoricalPosition.NetQty != 300 {
+			t.Errorf("%s total = %v -> %v, want 100 -> 300", name, event.OldHistoricalPosition.NetQty, event.NewHistoricalPosition.NetQty)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package minis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/utils"
+	"github.com/deltafund/components-support/broker"
+	"github.com/deltafund/components-support/storage"
+)
+
+type SlotState int
+
+const (
+	SLOT_IDLE SlotState = iota
+	SLOT_PENDING_NEW
+	SLOT_LIVE
+	SLOT_PENDING_REPLACE
+	SLOT_PENDING_CANCEL
+	SLOT_CANCEL_REJECTED
+)
+
+const MAX_PLACE_REJECTS int = 3
+
+func (s SlotState) String() string {
+	switch s {
+	case SLOT_IDLE:
+		return "idle"
+	case SLOT_PENDING_NEW:
+		return "pending-new"
+	case SLOT_LIVE:
+		return "live"
+	case SLOT_PENDING_REPLACE:
+		return "pending-replace"
+	case SLOT_PENDING_CANCEL:
+		return "pending-cancel"
+	case SLOT_CANCEL_REJECTED:
+		return "cancel-rejected"
+	}
+	return "unknown"
+}
+
+// OrderSlot maneja una unica orden de una estrategia y su ciclo de vida:
+//
+//	idle -> pending-new -> live -> pending-replace -> live
+//	                            -> pending-cancel -> idle | cancel-rejected
+//	cancel-rejected -> live (fill parcial o estado New/PartiallyFilled)
+//	cualquiera -> idle (estado terminal informado por el exchange)
+//
+// Los fills y las cancelaciones vuelven el slot a idle. Si se pide cancelar
+// mientras hay una accion pendiente de confirmacion, la cancelacion se manda
+// al llegar el ack. No es thread safe, lo protege el mutex de la estrategia.
+type OrderSlot struct {
+	state        SlotState
+	activeOrder  *order.Order
+	sentOrder    *order.Order
+	cancelWanted bool
+	rejects      int
+	sentAt       time.Time
+	forceCancels int
+	//primer ack vencido de la accion pendiente
+	stuckSince time.Time
+	//despues de una desconexion no se sabe si la orden sigue viva
+	uncertain bool
+
+	broker   broker.Broker
+	logger   *storage.Logger
+	listener interface{}
+	clock    Clock
+}
+
+func NewOrderSlot(broker broker.Broker, logger *storage.Logger, listener interface{}, clock Clock) *OrderSlot {
+	return &OrderSlot{
+		state:    SLOT_IDLE,
+		broker:   broker,
+		logger:   logger,
+		listener: listener,
+		clock:    clock,
+	}
+}
+
+func (slot *OrderSlot) SetClock(clock Clock) {
+	slot.clock = clock
+}
+
+func (slot *OrderSlot) State() SlotState {
+	return slot.state
+}
+
+func (slot *OrderSlot) Idle() bool {
+	return slot.state == SLOT_IDLE
+}
+
+// Pending indica que hay una accion esperando confirmacion del broker.
+func (slot *OrderSlot) Pending() bool {
+	return slot.state == SLOT_PENDING_NEW || slot.state == SLOT_PENDING_REPLACE || slot.state == SLOT_PENDING_CANCEL
+}
+
+func (slot *OrderSlot) Rejected() bool {
+	return slot.rejects >= MAX_PLACE_REJECTS
+}
+
+// Active es la orden confirmada por el exchange, nil si no hay.
+func (slot *OrderSlot) Active() *order.Order {
+	return slot.activeOrder
+}
+
+// Order es la ultima version conocida de la orden, enviada o confirmada.
+func (slot *OrderSlot) Order() *order.Order {
+	if slot.sentOrder != nil {
+		return slot.sentOrder
+	}
+	return slot.activeOrder
+}
+
+func (slot *OrderSlot) Leaves() float64 {
+	current := slot.Order()
+	if current == nil {
+		return 0.0
+	}
+	return current.Qty - current.CumQty
+}
+
+func (slot *OrderSlot) Owns(orderId string) bool {
+	return (slot.activeOrder != nil && slot.activeOrder.Id == orderId) ||
+		(slot.sentOrder != nil && slot.sentOrder.Id == orderId)
+}
+
+func (slot *OrderSlot) Place(request order.PlaceOrderRequest) bool {
+	if slot.state != SLOT_IDLE {
+		slot.logger.Printf("Cannot place order in state %v: %+v", slot.state, request)
+		return false
+	}
+
+	request.OrderId = utils.UUID()
+	newOrder, err := slot.broker.PlaceOrder(request, slot.listener)
+	if err != nil {
+		slot.logger.Printf("Cannot place new order: %+v. Error: %v", request, err)
+		return false
+	}
+
+	slot.sentOrder = newOrder
+	slot.setState(SLOT_PENDING_NEW)
+	return true
+}
+
+// Replace modifica la orden activa para que le queden leavesQty pendientes.
+func (slot *OrderSlot) Replace(px float64, leavesQty float64) bool {
+	if slot.state != SLOT_LIVE {
+		slot.logger.Printf("Cannot replace order in state %v", slot.state)
+		return false
+	}
+
+	request := order.ReplaceOrderRequest{
+		Order: *slot.activeOrder,
+		Qty:   slot.activeOrder.CumQty + leavesQty,
+		Px:    px,
+	}
+	err := slot.broker.ReplaceOrder(request)
+	if err != nil {
+		slot.logger.Printf("Cannot replace order %+v with request: %+v. Error: %s", slot.activeOrder, request, err)
+		return false
+	}
+
+	sentOrder := *slot.activeOrder
+	sentOrder.Px = request.Px
+	sentOrder.Qty = request.Qty
+	slot.sentOrder = &sentOrder
+	slot.setState(SLOT_PENDING_REPLACE)
+	return true
+}
+
+func (slot *OrderSlot) Cancel() {
+	switch slot.state {
+	case SLOT_PENDING_NEW, SLOT_PENDING_REPLACE:
+		slot.cancelWanted = true
+	case SLOT_LIVE:
+		request := order.CancelOrderRequest{
+			Order: *slot.activeOrder,
+		}
+		err := slot.broker.CancelOrder(request)
+		if err != nil {
+			slot.logger.Printf("Cannot cancel order %+v. Error %v", slot.activeOrder, err)
+			return
+		}
+		slot.sentOrder = slot.activeOrder
+		slot.setState(SLOT_PENDING_CANCEL)
+	}
+}
+
+func (slot *OrderSlot) Uncertain() bool {
+	return slot.uncertain
+}
+
+func (slot *OrderSlot) MarkUncertain() {
+	if slot.Order() != nil {
+		slot.uncertain = true
+	}
+}
+
+// Reconcile se llama al reconectar. Una orden de la que no se sabe el estado
+// se cancela; la cancelacion deja el slot en idle y un rechazo lo deja en
+// cancel-rejected hasta que llegue el estado de la orden.
+func (slot *OrderSlot) Reconcile() {
+	if !slot.uncertain {
+		return
+	}
+	slot.uncertain = false
+
+	uncertainOrder := slot.Order()
+	if uncertainOrder == nil {
+		slot.clear()
+		return
+	}
+
+	slot.logger.Printf("Reconciling order %+v in state %v after reconnect", uncertainOrder, slot.state)
+	err := slot.broker.CancelOrder(order.CancelOrderRequest{
+		Order: *uncertainOrder,
+	})
+	if err != nil {
+		slot.logger.Printf("Cannot cancel order %+v on reconnect. Error %v", uncertainOrder, err)
+	}
+	slot.forceCancels++
+	slot.sentOrder = uncertainOrder
+	slot.cancelWanted = false
+	slot.setState(SLOT_PENDING_CANCEL)
+}
+
+// CheckPendingAck revisa si la accion pendiente espera el ack hace mas de
+// timeout. Si es asi reenvia la cancelacion, que es la unica forma de que el
+// exchange informe el estado de la orden. El slot sigue bloqueado hasta que
+// llegue un reporte de la orden o un RESET_STATE, porque la orden puede
+// seguir viva. Un cancel rechazado sin estado de la orden tambien se avisa.
+// Devuelve una descripcion si hubo que actuar.
+func (slot *OrderSlot) CheckPendingAck(now time.Time, timeout time.Duration) (string, bool) {
+	if slot.state == SLOT_CANCEL_REJECTED && now.Sub(slot.sentAt) >= timeout {
+		alert := fmt.Sprintf("Order %+v cancel rejected %v ago, blocked until the order status or %v", slot.activeOrder, now.Sub(slot.sentAt), CMD_RESET_STATE)
+		slot.logger.Printf("%s", alert)
+		return alert, true
+	}
+	if !slot.Pending() || now.Sub(slot.sentAt) < timeout {
+		return "", false
+	}
+	if slot.stuckSince.IsZero() {
+		slot.stuckSince = slot.sentAt
+	}
+
+	stuckOrder := slot.Order()
+	alert := fmt.Sprintf("Order %+v pending acknowledgement in state %v for %v", stuckOrder, slot.state, now.Sub(slot.stuckSince))
+	if slot.forceCancels > 0 {
+		alert += fmt.Sprintf(". No answer to %d status requests, blocked until %v", slot.forceCancels, CMD_RESET_STATE)
+	}
+
+	slot.forceCancels++
+	err := slot.broker.CancelOrder(order.CancelOrderRequest{
+		Order: *stuckOrder,
+	})
+	if err != nil {
+		slot.logger.Printf("Cannot force cancel order %+v. Error %v", stuckOrder, err)
+	}
+	slot.sentOrder = stuckOrder
+	slot.cancelWanted = false
+	slot.setState(SLOT_PENDING_CANCEL)
+	slot.logger.Printf("%s", alert)
+	return alert + ". Cancel sent to request the order status", true
+}
+
+func (slot *OrderSlot) OnPlaced(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_PENDING_NEW) {
+		return false
+	}
+	placedOrder := event.Order
+	slot.activeOrder = &placedOrder
+	slot.sentOrder = nil
+	slot.rejects = 0
+	slot.state = SLOT_LIVE
+	slot.cancelIfWanted()
+	return true
+}
+
+func (slot *OrderSlot) OnPlaceRejected(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_PENDING_NEW) {
+		return false
+	}
+	slot.rejects++
+	if slot.Rejected() {
+		slot.logger.Printf("%d orders rejected in a row, slot blocked until reset", slot.rejects)
+	}
+	slot.clear()
+	return true
+}
+
+func (slot *OrderSlot) OnReplaced(event order.OrderEvent, newOrder *order.Order) bool {
+	if !slot.valid(event, SLOT_PENDING_REPLACE) {
+		return false
+	}
+	if newOrder != nil {
+		slot.activeOrder = newOrder
+	}
+	slot.sentOrder = nil
+	slot.state = SLOT_LIVE
+	slot.cancelIfWanted()
+	return true
+}
+
+// OnReplaceRejected deja la orden original viva; la estrategia decide si la
+// cancela para mandar una nueva.
+func (slot *OrderSlot) OnReplaceRejected(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_PENDING_REPLACE) {
+		return false
+	}
+	slot.sentOrder = nil
+	slot.state = SLOT_LIVE
+	slot.cancelIfWanted()
+	return true
+}
+
+func (slot *OrderSlot) OnCancelled(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_LIVE, SLOT_PENDING_REPLACE, SLOT_PENDING_CANCEL, SLOT_CANCEL_REJECTED) {
+		return false
+	}
+	slot.clear()
+	return true
+}
+
+func (slot *OrderSlot) OnCancelRejected(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_PENDING_CANCEL) {
+		return false
+	}
+	//la orden puede seguir viva aunque sea un pedido de estado: se guarda el id
+	//y el slot sigue bloqueado hasta un estado terminal o un RESET_STATE
+	if slot.activeOrder == nil {
+		slot.activeOrder = slot.sentOrder
+	}
+	slot.sentOrder = nil
+	slot.state = SLOT_CANCEL_REJECTED
+	slot.sentAt = slot.clock.Now()
+	return true
+}
+
+func (slot *OrderSlot) OnPartiallyFilled(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_PENDING_NEW, SLOT_LIVE, SLOT_PENDING_REPLACE, SLOT_PENDING_CANCEL, SLOT_CANCEL_REJECTED) {
+		return false
+	}
+	filledOrder := event.Order
+	slot.activeOrder = &filledOrder
+	if slot.state == SLOT_PENDING_NEW {
+		slot.sentOrder = nil
+		slot.state = SLOT_LIVE
+		slot.cancelIfWanted()
+	} else if slot.state == SLOT_CANCEL_REJECTED {
+		//el fill confirma que la orden sigue viva
+		slot.state = SLOT_LIVE
+	}
+	return true
+}
+
+// OnStatus toma el estado de la orden informado por el exchange. Un estado
+// terminal libera el slot; New o PartiallyFilled confirman que la orden sigue
+// viva y sacan al slot de un cancel rechazado. Con una accion pendiente solo
+// se actualiza la orden y se sigue esperando el ack.
+func (slot *OrderSlot) OnStatus(event order.OrderEvent) bool {
+	if !slot.Owns(event.Order.Id) || slot.state == SLOT_IDLE {
+		return false
+	}
+	switch event.Order.OrdStatus {
+	case order.OrdStatus_FILLED, order.OrdStatus_CANCELLED, order.OrdStatus_REJECTED, order.OrdStatus_EXPIRED:
+		slot.logger.Printf("Order %+v finished with status %v", event.Order, event.Order.OrdStatus)
+		slot.clear()
+		return true
+	case order.OrdStatus_NEW, order.OrdStatus_PARTIALLY_FILLED:
+		statusOrder := event.Order
+		slot.activeOrder = &statusOrder
+		if slot.state == SLOT_CANCEL_REJECTED {
+			slot.state = SLOT_LIVE
+		}
+		return true
+	}
+	slot.logger.Printf("Ignoring order status %v: %+v", event.Order.OrdStatus, event)
+	return false
+}
+
+func (slot *OrderSlot) OnFilled(event order.OrderEvent) bool {
+	if !slot.valid(event, SLOT_PENDING_NEW, SLOT_LIVE, SLOT_PENDING_REPLACE, SLOT_PENDING_CANCEL, SLOT_CANCEL_REJECTED) {
+		return false
+	}
+	slot.clear()
+	return true
+}
+
+// Reset olvida la orden sin avisar al broker. Se usa cuando el estado local
+// quedo trabado y ya se verifico la orden por otro medio.
+func (slot *OrderSlot) Reset() {
+	slot.logger.Printf("Resetting order slot in state %v: %+v", slot.state, slot.Order())
+	slot.clear()
+	slot.rejects = 0
+}
+
+func (slot *OrderSlot) valid(event order.OrderEvent, states ...SlotState) bool {
+	if !slot.Owns(event.Order.Id) {
+		slot.logger.Printf("Received order event for an unknown order: %+v", event)
+		return false
+	}
+	for _, state := range states {
+		if slot.state == state {
+			return true
+		}
+	}
+	slot.logger.Printf("Received order event in state %v: %+v", slot.state, event)
+	return false
+}
+
+func (slot *OrderSlot) cancelIfWanted() {
+	if slot.cancelWanted {
+		slot.cancelWanted = false
+		slot.Cancel()
+	}
+}
+
+func (slot *OrderSlot) setState(state SlotState) {
+	slot.state = state
+	if slot.Pending() {
+		slot.sentAt = slot.clock.Now()
+	}
+}
+
+func (slot *OrderSlot) clear() {
+	slot.activeOrder = nil
+	slot.sentOrder = nil
+	slot.cancelWanted = false
+	slot.forceCancels = 0
+	slot.stuckSince = time.Time{}
+	slot.uncertain = false
+	slot.state = SLOT_IDLE
+}
@@ -0,0 +1,212 @@
+package minis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/components-support/storage"
+)
+
+type slotStep struct {
+	name  string
+	do    func(slot *OrderSlot, fb *fakeBroker) bool
+	ok    bool
+	state SlotState
+}
+
+func slotEvent(fb *fakeBroker) order.OrderEvent {
+	return order.OrderEvent{Order: fb.lastOrder()}
+}
+
+var (
+	stepPlace = slotStep{"place", func(slot *OrderSlot, fb *fakeBroker) bool {
+		return slot.Place(order.PlaceOrderRequest{Security: testMini, Side: order.Side_BUY, Qty: 2, Px: 100})
+	}, true, SLOT_PENDING_NEW}
+	stepPlaced = slotStep{"placed", func(slot *OrderSlot, fb *fakeBroker) bool {
+		return slot.OnPlaced(slotEvent(fb))
+	}, true, SLOT_LIVE}
+	stepCancel = slotStep{"cancel", func(slot *OrderSlot, fb *fakeBroker) bool {
+		slot.Cancel()
+		return true
+	}, true, SLOT_PENDING_CANCEL}
+	stepCancelRejected = slotStep{"cancel rejected", func(slot *OrderSlot, fb *fakeBroker) bool {
+		return slot.OnCancelRejected(slotEvent(fb))
+	}, true, SLOT_CANCEL_REJECTED}
+)
+
+func statusStep(status order.OrdStatus, ok bool, state SlotState) slotStep {
+	return slotStep{"status", func(slot *OrderSlot, fb *fakeBroker) bool {
+		event := slotEvent(fb)
+		event.Order.OrdStatus = status
+		return slot.OnStatus(event)
+	}, ok, state}
+}
+
+func TestOrderSlotTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []slotStep
+	}{
+		{"place and ack", []slotStep{stepPlace, stepPlaced}},
+		{"replace", []slotStep{stepPlace, stepPlaced,
+			{"replace", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.Replace(99.5, 1)
+			}, true, SLOT_PENDING_REPLACE},
+			{"replaced", func(slot *OrderSlot, fb *fakeBroker) bool {
+				newOrder := fb.lastOrder()
+				newOrder.Px = fb.replaces[0].Px
+				newOrder.Qty = fb.replaces[0].Qty
+				return slot.OnReplaced(slotEvent(fb), &newOrder) && slot.Active().Px == 99.5 && slot.Leaves() == 1
+			}, true, SLOT_LIVE},
+		}},
+		{"replace rejected", []slotStep{stepPlace, stepPlaced,
+			{"replace", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.Replace(99.5, 1)
+			}, true, SLOT_PENDING_REPLACE},
+			{"replace rejected", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnReplaceRejected(slotEvent(fb)) && slot.Active().Px == 100
+			}, true, SLOT_LIVE},
+		}},
+		{"cancel", []slotStep{stepPlace, stepPlaced, stepCancel,
+			{"cancelled", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnCancelled(slotEvent(fb))
+			}, true, SLOT_IDLE},
+		}},
+		{"cancel while pending new", []slotStep{stepPlace,
+			{"cancel", func(slot *OrderSlot, fb *fakeBroker) bool {
+				slot.Cancel()
+				return len(fb.cancels) == 0
+			}, true, SLOT_PENDING_NEW},
+			{"placed", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnPlaced(slotEvent(fb)) && len(fb.cancels) == 1
+			}, true, SLOT_PENDING_CANCEL},
+		}},
+		{"cancel rejected then fill", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			{"partially filled", func(slot *OrderSlot, fb *fakeBroker) bool {
+				event := slotEvent(fb)
+				event.Order.CumQty = 1
+				return slot.OnPartiallyFilled(event) && slot.Leaves() == 1
+			}, true, SLOT_LIVE},
+		}},
+		{"cancel rejected then new", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			statusStep(order.OrdStatus_NEW, true, SLOT_LIVE)}},
+		{"cancel rejected then partially filled", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			statusStep(order.OrdStatus_PARTIALLY_FILLED, true, SLOT_LIVE)}},
+		{"cancel rejected then status filled", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			statusStep(order.OrdStatus_FILLED, true, SLOT_IDLE)}},
+		{"cancel rejected then status cancelled", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			statusStep(order.OrdStatus_CANCELLED, true, SLOT_IDLE)}},
+		{"cancel rejected then status rejected", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			statusStep(order.OrdStatus_REJECTED, true, SLOT_IDLE)}},
+		{"live then status cancelled", []slotStep{stepPlace, stepPlaced,
+			statusStep(order.OrdStatus_CANCELLED, true, SLOT_IDLE)}},
+		{"pending cancel then status new", []slotStep{stepPlace, stepPlaced, stepCancel,
+			statusStep(order.OrdStatus_NEW, true, SLOT_PENDING_CANCEL)}},
+		{"status when idle", []slotStep{stepPlace, stepPlaced, stepCancel,
+			{"cancelled", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnCancelled(slotEvent(fb))
+			}, true, SLOT_IDLE},
+			statusStep(order.OrdStatus_NEW, false, SLOT_IDLE)}},
+		{"cancel rejected then filled", []slotStep{stepPlace, stepPlaced, stepCancel, stepCancelRejected,
+			{"filled", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnFilled(slotEvent(fb))
+			}, true, SLOT_IDLE},
+		}},
+		{"stale ack", []slotStep{stepPlace, stepPlaced,
+			{"placed again", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnPlaced(slotEvent(fb))
+			}, false, SLOT_LIVE},
+			{"stale cancel", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnCancelRejected(slotEvent(fb))
+			}, false, SLOT_LIVE},
+		}},
+		{"foreign ack", []slotStep{stepPlace,
+			{"foreign placed", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnPlaced(order.OrderEvent{Order: order.Order{Id: "other"}})
+			}, false, SLOT_PENDING_NEW},
+			{"foreign status", func(slot *OrderSlot, fb *fakeBroker) bool {
+				return slot.OnStatus(order.OrderEvent{Order: order.Order{Id: "other"}})
+			}, false, SLOT_PENDING_NEW},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &fakeBroker{}
+			slot := NewOrderSlot(fb, storage.NewLogger("test"), nil, newFakeClock())
+			for _, step := range tt.steps {
+				if ok := step.do(slot, fb); ok != step.ok {
+					t.Fatalf("%s returned %v, want %v", step.name, ok, step.ok)
+				}
+				if slot.State() != step.state {
+					t.Fatalf("after %s state = %v, want %v", step.name, slot.State(), step.state)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderSlotRejects(t *testing.T) {
+	fb := &fakeBroker{}
+	slot := NewOrderSlot(fb, storage.NewLogger("test"), nil, newFakeClock())
+	for i := 0; i < MAX_PLACE_REJECTS; i++ {
+		if slot.Rejected() {
+			t.Fatalf("rejected after %d rejects", i)
+		}
+		stepPlace.do(slot, fb)
+		if !slot.OnPlaceRejected(slotEvent(fb)) || !slot.Idle() {
+			t.Fatalf("reject %d not applied, state %v", i, slot.State())
+		}
+	}
+	if !slot.Rejected() {
+		t.Fatalf("not rejected after %d rejects", MAX_PLACE_REJECTS)
+	}
+
+	slot.Reset()
+	if slot.Rejected() {
+		t.Fatalf("still rejected after reset")
+	}
+
+	//un ack confirma la orden y empieza la cuenta de nuevo
+	stepPlace.do(slot, fb)
+	slot.OnPlaceRejected(slotEvent(fb))
+	stepPlace.do(slot, fb)
+	stepPlaced.do(slot, fb)
+	if slot.rejects != 0 {
+		t.Fatalf("rejects = %d after an ack, want 0", slot.rejects)
+	}
+}
+
+func TestOrderSlotRejectedProbeKeepsOrder(t *testing.T) {
+	fb := &fakeBroker{}
+	clock := newFakeClock()
+	slot := NewOrderSlot(fb, storage.NewLogger("test"), nil, clock)
+	stepPlace.do(slot, fb)
+	placed := fb.lastOrder()
+
+	//sin ack se pide el estado con un cancel, que el exchange rechaza
+	clock.Advance(time.Minute)
+	if _, stuck := slot.CheckPendingAck(clock.Now(), 30*time.Second); !stuck || len(fb.cancels) != 1 {
+		t.Fatalf("no status request after the ack timeout, cancels %+v", fb.cancels)
+	}
+	if !slot.OnCancelRejected(order.OrderEvent{Order: placed}) {
+		t.Fatalf("probe cancel reject not applied")
+	}
+	if slot.State() != SLOT_CANCEL_REJECTED || !slot.Owns(placed.Id) {
+		t.Fatalf("state %v, owns %v after the rejected probe", slot.State(), slot.Owns(placed.Id))
+	}
+	if slot.Place(order.PlaceOrderRequest{Security: testMini, Side: order.Side_BUY, Qty: 2, Px: 100}) || len(fb.places) != 1 {
+		t.Fatalf("placed a second order while the first is unresolved: %+v", fb.places)
+	}
+
+	clock.Advance(time.Minute)
+	if _, stuck := slot.CheckPendingAck(clock.Now(), 30*time.Second); !stuck {
+		t.Fatalf("no alert for an order without status")
+	}
+
+	cancelled := placed
+	cancelled.OrdStatus = order.OrdStatus_CANCELLED
+	if !slot.OnStatus(order.OrderEvent{Order: cancelled}) || !slot.Idle() {
+		t.Fatalf("terminal status did not free the slot, state %v", slot.State())
+	}
+}
@@ -0,0 +1,274 @@
+package minis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/deltafund/api-fix/order"
+)
+
+const (
+	MAX_SIDE_VOL       float64 = 5.0
+	DEFAULT_QTY        float64 = 10.0
+	DEFAULT_MIN_QTY    float64 = 1.0
+	DEFAULT_MAX_QTY    float64 = 50.0
+	DEFAULT_REDUCE_QTY float64 = 0.1
+)
+
+// SideParams son los parametros de una punta. ReducePer10Tons es la fraccion
+// de Qty que se descuenta por cada 10 toneladas de posicion en la punta que
+// aumenta el inventario.
+type SideParams struct {
+	Vol             float64 `json:"vol"`
+	Qty             float64 `json:"qty"`
+	MinQty          float64 `json:"minQty"`
+	MaxQty          float64 `json:"maxQty"`
+	ReducePer10Tons float64 `json:"reducePer10Tons"`
+}
+
+func defaultSideParams() SideParams {
+	return SideParams{
+		Qty:             DEFAULT_QTY,
+		MinQty:          DEFAULT_MIN_QTY,
+		MaxQty:          DEFAULT_MAX_QTY,
+		ReducePer10Tons: DEFAULT_REDUCE_QTY,
+	}
+}
+
+func (sp *SideParams) fillDefaults() {
+	defaults := defaultSideParams()
+	if sp.Qty <= 0.0 {
+		sp.Qty = defaults.Qty
+	}
+	if sp.MinQty <= 0.0 {
+		sp.MinQty = defaults.MinQty
+	}
+	if sp.MaxQty <= 0.0 {
+		sp.MaxQty = defaults.MaxQty
+	}
+	if sp.ReducePer10Tons < 0.0 || sp.ReducePer10Tons > 1.0 {
+		sp.ReducePer10Tons = defaults.ReducePer10Tons
+	}
+}
+
+// QtyFor devuelve la cantidad a cotizar segun la posicion neta en toneladas,
+// reduciendo la punta que aumenta el inventario y acotando entre MinQty y MaxQty.
+func (sp SideParams) QtyFor(side order.Side, netQty float64) float64 {
+	qty := sp.Qty
+	increasesInventory := (side == order.Side_BUY && netQty > 0) || (side == order.Side_SELL && netQty < 0)
+	if increasesInventory {
+		qty = qty * (1 - sp.ReducePer10Tons*math.Abs(netQty)/10)
+	}
+
+	qty = math.Floor(qty)
+	return math.Min(math.Max(qty, sp.MinQty), sp.MaxQty)
+}
+
+const (
+	HEDGE_PX_PASSIVE int = 0
+	HEDGE_PX_MID     int = 1
+	HEDGE_PX_CROSS   int = 2
+)
+
+// AssetParams agrega a las puntas el modo de precio de las coberturas del
+// balancer. ProtectionTicks es cuanto puede pasarse de la punta contraria
+// una cobertura que cruza el spread.
+type AssetParams struct {
+	Bid             SideParams `json:"bid"`
+	Ask             SideParams `json:"ask"`
+	HedgePxMode     int        `json:"hedgePxMode"`
+	ProtectionTicks float64    `json:"protectionTicks"`
+}
+
+func newAssetParams() *AssetParams {
+	return &AssetParams{
+		Bid: defaultSideParams(),
+		Ask: defaultSideParams(),
+	}
+}
+
+// ParamStore guarda los parametros por activo y por punta que se cambian desde
+// el front. Si tiene path se persisten en un json para sobrevivir reinicios.
+type ParamStore struct {
+	mutex  sync.Mutex
+	path   string
+	assets map[string]*AssetParams
+}
+
+func NewParamStore(path string) (*ParamStore, error) {
+	ps := &ParamStore{
+		path:   path,
+		assets: make(map[string]*AssetParams),
+	}
+	if path == "" {
+		return ps, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	//cada activo parte de los defaults, asi los campos que faltan en archivos
+	//viejos (p.ej. reducePer10Tons) no quedan en 0
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse params file %s: %v", path, err)
+	}
+	for symbol, assetData := range raw {
+		asset := newAssetParams()
+		if err := json.Unmarshal(assetData, asset); err != nil {
+			return nil, fmt.Errorf("cannot parse params of %s in %s: %v", symbol, path, err)
+		}
+		asset.Bid.fillDefaults()
+		asset.Ask.fillDefaults()
+		ps.assets[symbol] = asset
+	}
+	return ps, nil
+}
+
+func (ps *ParamStore) Side(symbol string, side order.Side) SideParams {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return *ps.side(symbol, side)
+}
+
+func (ps *ParamStore) SetVol(symbol string, side order.Side, vol float64) error {
+	if invalidParam(vol) || vol < 0.0 || vol > MAX_SIDE_VOL {
+		return fmt.Errorf("vol %v out of range [0, %v]", vol, MAX_SIDE_VOL)
+	}
+
+	return ps.updateSide(symbol, side, func(params *SideParams) error {
+		params.Vol = vol
+		return nil
+	})
+}
+
+func (ps *ParamStore) SetQty(symbol string, side order.Side, qty float64) error {
+	if invalidParam(qty) {
+		return fmt.Errorf("invalid qty %v", qty)
+	}
+
+	return ps.updateSide(symbol, side, func(params *SideParams) error {
+		if qty < params.MinQty || qty > params.MaxQty {
+			return fmt.Errorf("qty %v out of range [%v, %v]", qty, params.MinQty, params.MaxQty)
+		}
+		params.Qty = qty
+		return nil
+	})
+}
+
+func (ps *ParamStore) SetQtyLimits(symbol string, side order.Side, minQty float64, maxQty float64, reducePer10Tons float64) error {
+	if invalidParam(minQty) || invalidParam(maxQty) || minQty <= 0.0 || maxQty < minQty {
+		return fmt.Errorf("invalid qty limits [%v, %v]", minQty, maxQty)
+	}
+	if invalidParam(reducePer10Tons) || reducePer10Tons < 0.0 || reducePer10Tons > 1.0 {
+		return fmt.Errorf("reducePer10Tons %v out of range [0, 1]", reducePer10Tons)
+	}
+
+	return ps.updateSide(symbol, side, func(params *SideParams) error {
+		params.MinQty = minQty
+		params.MaxQty = maxQty
+		params.ReducePer10Tons = reducePer10Tons
+		params.Qty = math.Min(math.Max(params.Qty, minQty), maxQty)
+		return nil
+	})
+}
+
+func (ps *ParamStore) HedgePricing(symbol string) (int, float64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	asset := ps.asset(symbol)
+	return asset.HedgePxMode, asset.ProtectionTicks
+}
+
+func (ps *ParamStore) SetHedgePxMode(symbol string, mode int) error {
+	if mode != HEDGE_PX_PASSIVE && mode != HEDGE_PX_MID && mode != HEDGE_PX_CROSS {
+		return fmt.Errorf("unknown hedge px mode %v", mode)
+	}
+
+	return ps.update(symbol, func(asset *AssetParams) error {
+		asset.HedgePxMode = mode
+		return nil
+	})
+}
+
+func (ps *ParamStore) SetProtectionTicks(symbol string, protectionTicks float64) error {
+	if invalidParam(protectionTicks) || protectionTicks < 0.0 {
+		return fmt.Errorf("protection ticks must not be negative: %v", protectionTicks)
+	}
+
+	return ps.update(symbol, func(asset *AssetParams) error {
+		asset.ProtectionTicks = protectionTicks
+		return nil
+	})
+}
+
+// update aplica el cambio sobre una copia del activo y solo la deja en memoria
+// si se pudo guardar, asi memoria y archivo no quedan distintos.
+func (ps *ParamStore) update(symbol string, change func(asset *AssetParams) error) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	asset := *ps.asset(symbol)
+	if err := change(&asset); err != nil {
+		return err
+	}
+
+	assets := make(map[string]*AssetParams, len(ps.assets))
+	for s, a := range ps.assets {
+		assets[s] = a
+	}
+	assets[symbol] = &asset
+	if err := ps.save(assets); err != nil {
+		return err
+	}
+	ps.assets = assets
+	return nil
+}
+
+func (ps *ParamStore) updateSide(symbol string, side order.Side, change func(params *SideParams) error) error {
+	return ps.update(symbol, func(asset *AssetParams) error {
+		if side == order.Side_SELL {
+			return change(&asset.Ask)
+		}
+		return change(&asset.Bid)
+	})
+}
+
+func (ps *ParamStore) asset(symbol string) *AssetParams {
+	asset, ok := ps.assets[symbol]
+	if !ok {
+		asset = newAssetParams()
+		ps.assets[symbol] = asset
+	}
+	return asset
+}
+
+func (ps *ParamStore) side(symbol string, side order.Side) *SideParams {
+	asset := ps.asset(symbol)
+	if side == order.Side_SELL {
+		return &asset.Ask
+	}
+	return &asset.Bid
+}
+
+func (ps *ParamStore) save(assets map[string]*AssetParams) error {
+	if ps.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(assets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ps.path, data, 0644)
+}
+
+func invalidParam(value float64) bool {
+	return math.IsNaN(value) || math.IsInf(value, 0)
+}
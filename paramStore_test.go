@@ -0,0 +1,96 @@
+package minis
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deltafund/api-fix/order"
+)
+
+func TestParamStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	ps, err := NewParamStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetVol("SOJ.MIN/MAY24", order.Side_BUY, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetQtyLimits("SOJ.MIN/MAY24", order.Side_SELL, 2, 20, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetHedgePxMode("SOJ.ROS/MAY24", HEDGE_PX_CROSS); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewParamStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid := loaded.Side("SOJ.MIN/MAY24", order.Side_BUY); bid != ps.Side("SOJ.MIN/MAY24", order.Side_BUY) || bid.Vol != 0.5 {
+		t.Fatalf("bid = %+v", bid)
+	}
+	if ask := loaded.Side("SOJ.MIN/MAY24", order.Side_SELL); ask.MinQty != 2 || ask.MaxQty != 20 || ask.ReducePer10Tons != 0 {
+		t.Fatalf("ask = %+v", ask)
+	}
+	if mode, _ := loaded.HedgePricing("SOJ.ROS/MAY24"); mode != HEDGE_PX_CROSS {
+		t.Fatalf("hedge px mode = %v", mode)
+	}
+}
+
+func TestParamStoreDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	data := `{"SOJ.MIN/MAY24": {"bid": {"vol": 0.3, "qty": 5}}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := NewParamStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultSideParams()
+	want.Vol, want.Qty = 0.3, 5
+	if bid := ps.Side("SOJ.MIN/MAY24", order.Side_BUY); bid != want {
+		t.Fatalf("bid = %+v, want %+v", bid, want)
+	}
+	if ask := ps.Side("SOJ.MIN/MAY24", order.Side_SELL); ask != defaultSideParams() {
+		t.Fatalf("ask = %+v, want defaults", ask)
+	}
+}
+
+func TestParamStoreRejectsInvalidValues(t *testing.T) {
+	ps, err := NewParamStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vol := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), -1, MAX_SIDE_VOL + 1} {
+		if err := ps.SetVol("SOJ.MIN/MAY24", order.Side_BUY, vol); err == nil {
+			t.Errorf("SetVol(%v) accepted", vol)
+		}
+	}
+	if err := ps.SetQty("SOJ.MIN/MAY24", order.Side_BUY, math.NaN()); err == nil {
+		t.Errorf("SetQty(NaN) accepted")
+	}
+	if bid := ps.Side("SOJ.MIN/MAY24", order.Side_BUY); bid != defaultSideParams() {
+		t.Fatalf("bid = %+v, want defaults", bid)
+	}
+}
+
+func TestParamStoreFailedSaveKeepsMemory(t *testing.T) {
+	ps, err := NewParamStore(filepath.Join(t.TempDir(), "missing", "params.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SetVol("SOJ.MIN/MAY24", order.Side_BUY, 0.5); err == nil {
+		t.Fatalf("SetVol saved to a missing directory")
+	}
+	if err := ps.SetQty("SOJ.MIN/MAY24", order.Side_BUY, 20); err == nil {
+		t.Fatalf("SetQty saved to a missing directory")
+	}
+	if bid := ps.Side("SOJ.MIN/MAY24", order.Side_BUY); bid != defaultSideParams() {
+		t.Fatalf("bid = %+v, want defaults", bid)
+	}
+}
@@ -0,0 +1,397 @@
+package minis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/broker"
+	"github.com/deltafund/components-support/settings"
+	"github.com/deltafund/components-support/storage"
+)
+
+// PnLPosition es una posicion en toneladas valuada a costo promedio. El PnL
+// esta en la moneda del precio por tonelada.
+type PnLPosition struct {
+	NetQty        float64
+	AvgPx         float64
+	RealizedPnL   float64
+	UnrealizedPnL float64
+}
+
+type PnLSnapshot struct {
+	Time       time.Time
+	MarkPx     float64
+	Combined   PnLPosition
+	Securities map[string]PnLPosition
+}
+
+// pnlBook acumula fills a costo promedio. Las toneladas son positivas
+// compradas y negativas vendidas.
+type pnlBook struct {
+	netQty   float64
+	avgPx    float64
+	realized float64
+}
+
+func (pb *pnlBook) fill(tons float64, px float64) {
+	if pb.netQty == 0.0 || math.Signbit(pb.netQty) == math.Signbit(tons) {
+		pb.avgPx = (pb.avgPx*math.Abs(pb.netQty) + px*math.Abs(tons)) / (math.Abs(pb.netQty) + math.Abs(tons))
+		pb.netQty += tons
+		return
+	}
+
+	closed := math.Min(math.Abs(tons), math.Abs(pb.netQty))
+	if pb.netQty > 0 {
+		pb.realized += closed * (px - pb.avgPx)
+	} else {
+		pb.realized += closed * (pb.avgPx - px)
+	}
+	pb.netQty += tons
+	if pb.netQty == 0.0 {
+		pb.avgPx = 0.0
+	} else if math.Abs(tons) > closed {
+		//dio vuelta la posicion, el resto abre al precio del fill
+		pb.avgPx = px
+	}
+}
+
+func (pb *pnlBook) position(markPx float64) PnLPosition {
+	unrealized := 0.0
+	if markPx > 0.0 && pb.netQty != 0.0 {
+		unrealized = pb.netQty * (markPx - pb.avgPx)
+	}
+	return PnLPosition{
+		NetQty:        pb.netQty,
+		AvgPx:         pb.avgPx,
+		RealizedPnL:   pb.realized,
+		UnrealizedPnL: unrealized,
+	}
+}
+
+// pnlFill es un fill registrado, para poder deshacerlo si el mercado lo anula.
+type pnlFill struct {
+	execId  string
+	account string
+	symbol  string
+	tons    float64
+	px      float64
+}
+
+// PnLEngine lleva el costo promedio y el PnL realizado por instrumento y de la
+// posicion combinada mini + std en toneladas. El mark to market usa el mid
+// del book del std, o la punta que haya si esta de un solo lado.
+type PnLEngine struct {
+	rwMutex       sync.RWMutex
+	logger        *storage.Logger
+	stdSecurity   security.Security
+	miniSecurity  security.Security
+	specs         *ContractSpecs
+	clock         Clock
+	traderUpdater TraderUpdater
+
+	securities map[string]*pnlBook
+	combined   pnlBook
+	fills      []pnlFill
+	markPx     float64
+
+	reportDir   string
+	reportAt    time.Duration
+	reportTimer Timer
+}
+
+func NewPnLEngine(stdSecurity security.Security, miniSecurity security.Security, specs *ContractSpecs, clock Clock) *PnLEngine {
+	if specs == nil {
+		specs = NewContractSpecs()
+	}
+	if clock == nil {
+		clock = NewSystemClock()
+	}
+	return &PnLEngine{
+		logger:       storage.NewLogger("pnl"),
+		stdSecurity:  stdSecurity,
+		miniSecurity: miniSecurity,
+		specs:        specs,
+		clock:        clock,
+		securities:   make(map[string]*pnlBook),
+	}
+}
+
+func (pe *PnLEngine) SetTraderUpdater(traderUpdater TraderUpdater) {
+	pe.rwMutex.Lock()
+	pe.traderUpdater = traderUpdater
+	pe.rwMutex.Unlock()
+}
+
+// OnExecution registra un fill. Los fills de otros instrumentos se ignoran.
+func (pe *PnLEngine) OnExecution(orderEvent order.OrderEvent) {
+	pe.rwMutex.Lock()
+	defer pe.rwMutex.Unlock()
+
+	fill, ok := pe.toFill(orderEvent)
+	if !ok {
+		return
+	}
+	pe.fills = append(pe.fills, fill)
+	book := pe.apply(fill)
+
+	pe.logger.Printf("%s fill %v tons @ %v, %s: %+v combined: %+v", fill.symbol, fill.tons, fill.px,
+		fill.symbol, book.position(pe.markPx), pe.combined.position(pe.markPx))
+}
+
+// Reverse deshace un fill anulado por el mercado. Se saca el fill con el mismo
+// ExecId y cuenta y se rearman los libros con los demas: la posicion y el costo
+// promedio vuelven a los de sin el trade y al realizado se le resta lo que el
+// trade aporto. Un fill que el engine no registro, de otra cuenta o ya
+// revertido, no cambia nada.
+func (pe *PnLEngine) Reverse(orderEvent order.OrderEvent) bool {
+	pe.rwMutex.Lock()
+	defer pe.rwMutex.Unlock()
+
+	busted, ok := pe.toFill(orderEvent)
+	if !ok {
+		return false
+	}
+	index := -1
+	for i := len(pe.fills) - 1; i >= 0; i-- {
+		fill := pe.fills[i]
+		if fill.account != busted.account {
+			continue
+		}
+		if busted.execId != "" && fill.execId == busted.execId {
+			index = i
+			break
+		}
+		if busted.execId == "" && fill == busted {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		pe.logger.Printf("Ignoring bust of an unknown fill %+v", orderEvent.ExecutionReport)
+		return false
+	}
+
+	//el realizado se corrige por diferencia, porque el reporte diario lo pone en cero
+	withSecurities, withCombined := replayFills(pe.fills)
+	pe.fills = append(pe.fills[:index], pe.fills[index+1:]...)
+	securities, combined := replayFills(pe.fills)
+	for symbol, book := range securities {
+		if current, ok := pe.securities[symbol]; ok {
+			book.realized = current.realized - (withSecurities[symbol].realized - book.realized)
+		}
+	}
+	combined.realized = pe.combined.realized - (withCombined.realized - combined.realized)
+	pe.securities = securities
+	pe.combined = combined
+	pe.logger.Printf("%s fill %v tons @ %v reversed, combined: %+v", busted.symbol, busted.tons, busted.px,
+		pe.combined.position(pe.markPx))
+	return true
+}
+
+// replayFills arma los libros desde cero con los fills dados.
+func replayFills(fills []pnlFill) (map[string]*pnlBook, pnlBook) {
+	securities := make(map[string]*pnlBook)
+	combined := pnlBook{}
+	for _, fill := range fills {
+		book, ok := securities[fill.symbol]
+		if !ok {
+			book = &pnlBook{}
+			securities[fill.symbol] = book
+		}
+		book.fill(fill.tons, fill.px)
+		combined.fill(fill.tons, fill.px)
+	}
+	return securities, combined
+}
+
+func (pe *PnLEngine) toFill(orderEvent order.OrderEvent) (pnlFill, bool) {
+	sizePerContract := 0.0
+	switch orderEvent.Order.Security.Symbol {
+	case pe.stdSecurity.Symbol:
+		sizePerContract = pe.specs.TonsPerContract(pe.stdSecurity)
+	case pe.miniSecurity.Symbol:
+		sizePerContract = pe.specs.TonsPerContract(pe.miniSecurity)
+	default:
+		return pnlFill{}, false
+	}
+	report := orderEvent.ExecutionReport
+	if report.Qty <= 0.0 || report.Px <= 0.0 {
+		return pnlFill{}, false
+	}
+
+	tons := report.Qty * sizePerContract
+	if report.Side == order.Side_SELL {
+		tons = -tons
+	}
+	return pnlFill{
+		execId:  report.ExecId,
+		account: orderEvent.Order.Account,
+		symbol:  orderEvent.Order.Security.Symbol,
+		tons:    tons,
+		px:      report.Px,
+	}, true
+}
+
+func (pe *PnLEngine) apply(fill pnlFill) *pnlBook {
+	book, ok := pe.securities[fill.symbol]
+	if !ok {
+		book = &pnlBook{}
+		pe.securities[fill.symbol] = book
+	}
+	book.fill(fill.tons, fill.px)
+	pe.combined.fill(fill.tons, fill.px)
+	return book
+}
+
+// OnBookUpdated toma el precio de valuacion del book del std.
+func (pe *PnLEngine) OnBookUpdated(bookUpdated marketdata.BookUpdated) {
+	if bookUpdated.Security.Symbol != pe.stdSecurity.Symbol {
+		return
+	}
+	bidPx, askPx := topOfBook(bookUpdated.Book)
+	markPx := 0.0
+	switch {
+	case bidPx > 0.0 && askPx > 0.0:
+		markPx = (bidPx + askPx) / 2
+	case bidPx > 0.0:
+		markPx = bidPx
+	case askPx > 0.0:
+		markPx = askPx
+	default:
+		return
+	}
+
+	pe.rwMutex.Lock()
+	pe.markPx = markPx
+	pe.rwMutex.Unlock()
+}
+
+func (pe *PnLEngine) Snapshot() PnLSnapshot {
+	pe.rwMutex.RLock()
+	defer pe.rwMutex.RUnlock()
+	return pe.snapshot()
+}
+
+func (pe *PnLEngine) snapshot() PnLSnapshot {
+	snapshot := PnLSnapshot{
+		Time:       pe.clock.Now(),
+		MarkPx:     pe.markPx,
+		Combined:   pe.combined.position(pe.markPx),
+		Securities: make(map[string]PnLPosition, len(pe.securities)),
+	}
+	for symbol, book := range pe.securities {
+		snapshot.Securities[symbol] = book.position(pe.markPx)
+	}
+	return snapshot
+}
+
+func (pe *PnLEngine) String() string {
+	snapshot := pe.Snapshot()
+	return fmt.Sprintf("%s PnL realized: %.2f unrealized: %.2f net: %v tons @ %.2f mark: %.2f",
+		pe.miniSecurity.Symbol, snapshot.Combined.RealizedPnL, snapshot.Combined.UnrealizedPnL,
+		snapshot.Combined.NetQty, snapshot.Combined.AvgPx, snapshot.MarkPx)
+}
+
+// StartDailyReport escribe el PnL del dia en dir todos los dias a la hora at
+// (desde la medianoche local) y empieza el realizado del dia siguiente en 0.
+func (pe *PnLEngine) StartDailyReport(dir string, at time.Duration) {
+	pe.rwMutex.Lock()
+	pe.reportDir = dir
+	pe.reportAt = at
+	pe.scheduleReport()
+	pe.rwMutex.Unlock()
+}
+
+func (pe *PnLEngine) StopDailyReport() {
+	pe.rwMutex.Lock()
+	if pe.reportTimer != nil {
+		pe.reportTimer.Stop()
+		pe.reportTimer = nil
+	}
+	pe.rwMutex.Unlock()
+}
+
+func (pe *PnLEngine) scheduleReport() {
+	if pe.reportTimer != nil {
+		pe.reportTimer.Stop()
+	}
+	now := pe.clock.Now()
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Add(pe.reportAt)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	pe.reportTimer = pe.clock.AfterFunc(next.Sub(now), pe.dailyReport)
+}
+
+func (pe *PnLEngine) dailyReport() {
+	pe.rwMutex.Lock()
+	defer pe.rwMutex.Unlock()
+	if pe.reportTimer == nil {
+		return
+	}
+
+	snapshot := pe.snapshot()
+	if err := pe.writeReport(snapshot); err != nil {
+		pe.logger.Printf("Cannot write daily PnL report: %v", err)
+	} else {
+		for _, book := range pe.securities {
+			book.realized = 0.0
+		}
+		pe.combined.realized = 0.0
+	}
+	pe.scheduleReport()
+}
+
+func (pe *PnLEngine) writeReport(snapshot PnLSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	//el ticker tiene "/" (SOJ.MIN/MAY24)
+	symbol := strings.ReplaceAll(pe.miniSecurity.Symbol, "/", "-")
+	name := filepath.Join(pe.reportDir, fmt.Sprintf("pnl-%s-%s.json", symbol, snapshot.Time.Format("2006-01-02")))
+	pe.logger.Printf("Daily PnL report %s: %+v", name, snapshot.Combined)
+	return os.WriteFile(name, data, 0644)
+}
+
+// StartPnLSubscriptions conecta el motor de PnL a los fills de la posicion
+// neta, al book del std y a los comandos del front, que responde por
+// traderUpdater.
+func StartPnLSubscriptions(settingsManager *settings.SettingsManager, myBroker broker.DefaultBroker, stdFuture security.Security, netFuture *NetFuturePosition, pnl *PnLEngine, traderUpdater TraderUpdater) {
+	netFuture.SetPnLEngine(pnl)
+	if traderUpdater != nil {
+		pnl.SetTraderUpdater(traderUpdater)
+	}
+	myBroker.SubscribeBook(stdFuture, pnl)
+	settingsManager.Subscribe(pnl)
+}
+
+// settings callbacks ///
+
+func (pe *PnLEngine) OnCommand(command settings.FrontCommand) {
+	if command.Command != CMD_DUMP_PNL || !commandFor(command, pe.stdSecurity.Symbol, pe.miniSecurity.Symbol) {
+		return
+	}
+	msg := pe.String()
+	pe.logger.Printf("%s", msg)
+
+	pe.rwMutex.RLock()
+	traderUpdater := pe.traderUpdater
+	pe.rwMutex.RUnlock()
+	if traderUpdater != nil {
+		traderUpdater.SendToast(msg)
+	}
+}
+
+func (pe *PnLEngine) OnBotSettingChange(botSetting settings.BotSetting)       {}
+func (pe *PnLEngine) OnBotEnabledChange(botEnabled settings.Enabled)          {}
+func (pe *PnLEngine) OnAssetSettingChange(assetSetting settings.AssetSetting) {}
@@ -0,0 +1,220 @@
+package minis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/components-support/settings"
+)
+
+// SpreadModel calcula el spread que el market maker aplica sobre el precio de
+// mercado a partir del book del futuro std.
+type SpreadModel interface {
+	Spread(book marketdata.Book) float64
+}
+
+// SideSpreadModel es un modelo con estado que se actualiza con cada book. Cada
+// market maker usa su propia instancia, asi el bid y el ask no avanzan dos
+// veces el mismo estado por book.
+type SideSpreadModel interface {
+	SpreadModel
+	ForSide() SpreadModel
+}
+
+// DefaultSpreadModel son los tiers originales: 0.1 si el book del std esta
+// cerrado, 0.3 si esta abierto y 0.5 si hay una sola punta.
+type DefaultSpreadModel struct{}
+
+func NewDefaultSpreadModel() *DefaultSpreadModel {
+	return &DefaultSpreadModel{}
+}
+
+func (m *DefaultSpreadModel) Spread(book marketdata.Book) float64 {
+	bidPx, askPx := topOfBook(book)
+
+	spread := 0.0
+	if askPx == 0.0 && bidPx == 0.0 {
+		return spread
+	}
+
+	if askPx == 0.0 || bidPx == 0.0 {
+		spread = 0.5
+		return spread
+	}
+
+	futureSpread := askPx - bidPx
+	if futureSpread > 1 {
+		spread = 0.3
+	} else if futureSpread < 0.9 {
+		spread = 0.1
+	}
+
+	return spread
+}
+
+type SpreadTier struct {
+	MaxWidth float64
+	Spread   float64
+}
+
+// TieredSpreadModel usa el primer tier cuyo MaxWidth cubre el ancho del book
+// del std. Si el ancho supera todos los tiers se usa el ultimo.
+type TieredSpreadModel struct {
+	rwMutex  sync.RWMutex
+	tiers    []SpreadTier
+	oneSided float64
+}
+
+func NewTieredSpreadModel(tiers []SpreadTier, oneSided float64) *TieredSpreadModel {
+	return &TieredSpreadModel{
+		tiers:    sortTiers(tiers),
+		oneSided: oneSided,
+	}
+}
+
+func (m *TieredSpreadModel) Spread(book marketdata.Book) float64 {
+	m.rwMutex.RLock()
+	defer m.rwMutex.RUnlock()
+
+	bidPx, askPx := topOfBook(book)
+	if askPx == 0.0 && bidPx == 0.0 {
+		return 0.0
+	}
+	if askPx == 0.0 || bidPx == 0.0 {
+		return m.oneSided
+	}
+	if len(m.tiers) == 0 {
+		return 0.0
+	}
+
+	width := askPx - bidPx
+	for _, tier := range m.tiers {
+		if width <= tier.MaxWidth {
+			return tier.Spread
+		}
+	}
+	return m.tiers[len(m.tiers)-1].Spread
+}
+
+// ApplyBotSetting cambia un tier o el spread de una sola punta. Los tiers se
+// numeran por ancho creciente; se puede agregar solo el siguiente al ultimo.
+func (m *TieredSpreadModel) ApplyBotSetting(botSetting settings.BotSetting) (bool, error) {
+	index, isWidth := 0, false
+	switch botSetting.Key {
+	case SPREAD_ONE_SIDED:
+		m.rwMutex.Lock()
+		m.oneSided = botSetting.Value
+		m.rwMutex.Unlock()
+		return true, nil
+	case SPREAD_TIER_1_WIDTH:
+		index, isWidth = 0, true
+	case SPREAD_TIER_1:
+		index = 0
+	case SPREAD_TIER_2_WIDTH:
+		index, isWidth = 1, true
+	case SPREAD_TIER_2:
+		index = 1
+	case SPREAD_TIER_3_WIDTH:
+		index, isWidth = 2, true
+	case SPREAD_TIER_3:
+		index = 2
+	default:
+		return false, nil
+	}
+
+	m.rwMutex.Lock()
+	defer m.rwMutex.Unlock()
+	if index > len(m.tiers) {
+		return true, fmt.Errorf("spread tier %d not configured, %d tiers", index+1, len(m.tiers))
+	}
+
+	tiers := make([]SpreadTier, len(m.tiers), len(m.tiers)+1)
+	copy(tiers, m.tiers)
+	if index == len(tiers) {
+		tiers = append(tiers, SpreadTier{MaxWidth: math.MaxFloat64})
+	}
+	if isWidth {
+		tiers[index].MaxWidth = botSetting.Value
+	} else {
+		tiers[index].Spread = botSetting.Value
+	}
+	m.tiers = sortTiers(tiers)
+	return true, nil
+}
+
+func sortTiers(tiers []SpreadTier) []SpreadTier {
+	sorted := make([]SpreadTier, len(tiers))
+	copy(sorted, tiers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MaxWidth < sorted[j].MaxWidth
+	})
+	return sorted
+}
+
+// VolatilitySpreadModel escala el spread con un promedio exponencial de las
+// variaciones absolutas del mid del std: base + multiplier * vol, acotado
+// entre minSpread y maxSpread.
+type VolatilitySpreadModel struct {
+	mutex      sync.Mutex
+	base       float64
+	multiplier float64
+	minSpread  float64
+	maxSpread  float64
+	oneSided   float64
+	alpha      float64
+
+	lastMid float64
+	vol     float64
+}
+
+func NewVolatilitySpreadModel(base float64, multiplier float64, minSpread float64, maxSpread float64, oneSided float64, alpha float64) *VolatilitySpreadModel {
+	return &VolatilitySpreadModel{
+		base:       base,
+		multiplier: multiplier,
+		minSpread:  minSpread,
+		maxSpread:  maxSpread,
+		oneSided:   oneSided,
+		alpha:      alpha,
+	}
+}
+
+// ForSide es un modelo con los mismos parametros y la volatilidad en cero.
+func (m *VolatilitySpreadModel) ForSide() SpreadModel {
+	return NewVolatilitySpreadModel(m.base, m.multiplier, m.minSpread, m.maxSpread, m.oneSided, m.alpha)
+}
+
+func (m *VolatilitySpreadModel) Spread(book marketdata.Book) float64 {
+	bidPx, askPx := topOfBook(book)
+	if askPx == 0.0 && bidPx == 0.0 {
+		return 0.0
+	}
+	if askPx == 0.0 || bidPx == 0.0 {
+		return m.oneSided
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mid := (bidPx + askPx) / 2
+	if m.lastMid > 0.0 {
+		m.vol = m.alpha*math.Abs(mid-m.lastMid) + (1-m.alpha)*m.vol
+	}
+	m.lastMid = mid
+
+	spread := m.base + m.multiplier*m.vol
+	return math.Min(math.Max(spread, m.minSpread), m.maxSpread)
+}
+
+func topOfBook(book marketdata.Book) (float64, float64) {
+	bidPx, askPx := 0.0, 0.0
+	if len(book.Bids) > 0 {
+		bidPx = book.Bids[0].Px
+	}
+	if len(book.Asks) > 0 {
+		askPx = book.Asks[0].Px
+	}
+	return bidPx, askPx
+}
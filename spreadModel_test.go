@@ -0,0 +1,93 @@
+package minis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/deltafund/components-support/settings"
+)
+
+func TestVolatilitySpreadModel(t *testing.T) {
+	m := NewVolatilitySpreadModel(0.1, 2, 0.1, 1, 0.5, 0.5)
+	tests := []struct {
+		bid    float64
+		ask    float64
+		spread float64
+	}{
+		{100, 101, 0.1},
+		{101, 102, 1},
+		{101, 102, 0.6},
+		{101, 102, 0.35},
+		{0, 102, 0.5},
+		{101, 102, 0.225},
+	}
+	for i, tt := range tests {
+		book := testBook(tt.bid, tt.ask)
+		if tt.bid == 0 {
+			book.Bids = nil
+		}
+		if spread := m.Spread(book); math.Abs(spread-tt.spread) > 1e-9 {
+			t.Errorf("book %d spread = %v, want %v", i, spread, tt.spread)
+		}
+	}
+}
+
+func TestVolatilitySpreadModelPerSide(t *testing.T) {
+	shared := NewVolatilitySpreadModel(0, 1, 0, 10, 0.5, 0.5)
+	bid := NewMinisMarketMaker(testMini, 1, "account", &fakeBroker{}, shared, nil, nil, nil)
+	ask := NewMinisMarketMaker(testMini, 2, "account", &fakeBroker{}, shared, nil, nil, nil)
+	if bid.spreadModel == ask.spreadModel {
+		t.Fatalf("bid and ask share the volatility state")
+	}
+
+	//el mismo book visto por los dos lados avanza la volatilidad una vez por lado
+	for _, book := range []struct{ bid, ask float64 }{{100, 101}, {102, 103}} {
+		bid.spreadModel.Spread(testBook(book.bid, book.ask))
+		ask.spreadModel.Spread(testBook(book.bid, book.ask))
+	}
+	single := NewVolatilitySpreadModel(0, 1, 0, 10, 0.5, 0.5)
+	single.Spread(testBook(100, 101))
+	want := single.Spread(testBook(102, 103))
+	if got := ask.spreadModel.Spread(testBook(102, 103)); math.Abs(got-want/2) > 1e-9 {
+		t.Fatalf("ask spread = %v, want %v", got, want/2)
+	}
+}
+
+func TestTieredSpreadModelApplyBotSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting settings.BotSetting
+		applied bool
+		wantErr bool
+		bid     float64
+		ask     float64
+		spread  float64
+	}{
+		{"tier spread", settings.BotSetting{Key: SPREAD_TIER_1, Value: 0.2}, true, false, 100, 100.5, 0.2},
+		{"tier width", settings.BotSetting{Key: SPREAD_TIER_1_WIDTH, Value: 0.25}, true, false, 100, 100.5, 0.3},
+		{"new tier", settings.BotSetting{Key: SPREAD_TIER_3, Value: 0.7}, true, false, 100, 105, 0.7},
+		{"tier not configured", settings.BotSetting{Key: SPREAD_TIER_3, Value: 0.7}, true, true, 100, 100.5, 0.1},
+		{"one sided", settings.BotSetting{Key: SPREAD_ONE_SIDED, Value: 0.8}, true, false, 0, 101, 0.8},
+		{"other key", settings.BotSetting{Key: HEDGE_TARGET, Value: 1}, false, false, 100, 100.5, 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers := []SpreadTier{{MaxWidth: 1, Spread: 0.1}, {MaxWidth: 3, Spread: 0.3}}
+			if tt.name == "tier not configured" {
+				tiers = tiers[:1]
+			}
+			m := NewTieredSpreadModel(tiers, 0.5)
+			applied, err := m.ApplyBotSetting(tt.setting)
+			if applied != tt.applied || (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyBotSetting(%+v) = %v, %v", tt.setting, applied, err)
+			}
+			book := testBook(tt.bid, tt.ask)
+			if tt.bid == 0 {
+				book.Bids = nil
+			}
+			if spread := m.Spread(book); spread != tt.spread {
+				t.Fatalf("spread = %v, want %v", spread, tt.spread)
+			}
+		})
+	}
+}
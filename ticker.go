@@ -0,0 +1,185 @@
+package minis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deltafund/api-fix/security"
+)
+
+var rofexMonths = []string{"ENE", "FEB", "MAR", "ABR", "MAY", "JUN", "JUL", "AGO", "SEP", "OCT", "NOV", "DIC"}
+
+// Ticker es un futuro de ROFEX descompuesto: SOJ.ROS/MAY24 es el producto SOJ
+// en el mercado ROS con vencimiento mayo 2024.
+type Ticker struct {
+	Product string
+	Harbour string
+	Month   time.Month
+	Year    int
+}
+
+func ParseTicker(symbol string) (Ticker, error) {
+	instrument, maturity, found := strings.Cut(symbol, "/")
+	if !found {
+		return Ticker{}, fmt.Errorf("ticker %q has no maturity", symbol)
+	}
+	product, harbour, found := strings.Cut(instrument, ".")
+	if !found || product == "" || harbour == "" {
+		return Ticker{}, fmt.Errorf("ticker %q has no product.harbour", symbol)
+	}
+	if len(maturity) != 5 {
+		return Ticker{}, fmt.Errorf("ticker %q has an invalid maturity %q", symbol, maturity)
+	}
+
+	month := time.Month(0)
+	for i, name := range rofexMonths {
+		if maturity[:3] == name {
+			month = time.Month(i + 1)
+			break
+		}
+	}
+	if month == 0 {
+		return Ticker{}, fmt.Errorf("ticker %q has an invalid month %q", symbol, maturity[:3])
+	}
+	//los dos ultimos caracteres tienen que ser digitos: Atoi aceptaria "-1" o "+1"
+	year := 0
+	for _, digit := range maturity[3:] {
+		if digit < '0' || digit > '9' {
+			return Ticker{}, fmt.Errorf("ticker %q has an invalid year %q", symbol, maturity[3:])
+		}
+		year = year*10 + int(digit-'0')
+	}
+
+	return Ticker{
+		Product: product,
+		Harbour: harbour,
+		Month:   month,
+		Year:    2000 + year,
+	}, nil
+}
+
+func (t Ticker) String() string {
+	return fmt.Sprintf("%s.%s/%s%02d", t.Product, t.Harbour, rofexMonths[t.Month-1], t.Year%100)
+}
+
+// Instrument es el ticker sin vencimiento, p.ej. SOJ.ROS.
+func (t Ticker) Instrument() string {
+	return t.Product + "." + t.Harbour
+}
+
+func (t Ticker) NextMonth() Ticker {
+	if t.Month == time.December {
+		t.Month = time.January
+		t.Year++
+	} else {
+		t.Month++
+	}
+	return t
+}
+
+// SymbolMap relaciona minis con sus std. Por defecto el mini de un producto
+// en MIN se cubre con el mismo producto y vencimiento en ROS; Register pisa
+// la relacion para un ticker puntual.
+type SymbolMap struct {
+	rwMutex      sync.RWMutex
+	harbourToStd map[string]string
+	miniToStd    map[string]string
+}
+
+func NewSymbolMap() *SymbolMap {
+	return &SymbolMap{
+		harbourToStd: map[string]string{"MIN": "ROS"},
+		miniToStd:    make(map[string]string),
+	}
+}
+
+func (sm *SymbolMap) RegisterHarbour(miniHarbour string, stdHarbour string) {
+	sm.rwMutex.Lock()
+	sm.harbourToStd[miniHarbour] = stdHarbour
+	sm.rwMutex.Unlock()
+}
+
+func (sm *SymbolMap) Register(miniSymbol string, stdSymbol string) {
+	sm.rwMutex.Lock()
+	sm.miniToStd[miniSymbol] = stdSymbol
+	sm.rwMutex.Unlock()
+}
+
+func (sm *SymbolMap) StdFor(miniSymbol string) (string, error) {
+	sm.rwMutex.RLock()
+	defer sm.rwMutex.RUnlock()
+
+	if stdSymbol, ok := sm.miniToStd[miniSymbol]; ok {
+		return stdSymbol, nil
+	}
+	ticker, err := ParseTicker(miniSymbol)
+	if err != nil {
+		return "", err
+	}
+	stdHarbour, ok := sm.harbourToStd[ticker.Harbour]
+	if !ok {
+		return "", fmt.Errorf("%s is not a mini, harbour %s", miniSymbol, ticker.Harbour)
+	}
+	ticker.Harbour = stdHarbour
+	return ticker.String(), nil
+}
+
+func (sm *SymbolMap) MiniFor(stdSymbol string) (string, error) {
+	sm.rwMutex.RLock()
+	defer sm.rwMutex.RUnlock()
+
+	//si mas de un mini cubre con el mismo std gana el primero en orden alfabetico
+	for _, miniSymbol := range sortedKeys(sm.miniToStd) {
+		if sm.miniToStd[miniSymbol] == stdSymbol {
+			return miniSymbol, nil
+		}
+	}
+	ticker, err := ParseTicker(stdSymbol)
+	if err != nil {
+		return "", err
+	}
+	for _, miniHarbour := range sortedKeys(sm.harbourToStd) {
+		if sm.harbourToStd[miniHarbour] == ticker.Harbour {
+			ticker.Harbour = miniHarbour
+			return ticker.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no mini, harbour %s", stdSymbol, ticker.Harbour)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NextMonth es el mismo instrumento con el vencimiento siguiente.
+func (sm *SymbolMap) NextMonth(symbol string) (string, error) {
+	ticker, err := ParseTicker(symbol)
+	if err != nil {
+		return "", err
+	}
+	return ticker.NextMonth().String(), nil
+}
+
+// StdSecurity arma el std que cubre al mini miniSecurity.
+func (sm *SymbolMap) StdSecurity(miniSecurity security.Security) (security.Security, error) {
+	stdSymbol, err := sm.StdFor(miniSecurity.Symbol)
+	if err != nil {
+		return security.Security{}, err
+	}
+	ticker, err := ParseTicker(stdSymbol)
+	if err != nil {
+		return security.Security{}, err
+	}
+	stdSecurity := miniSecurity
+	stdSecurity.Symbol = stdSymbol
+	stdSecurity.Harbour = ticker.Harbour
+	return stdSecurity, nil
+}
@@ -0,0 +1,93 @@
+package minis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTicker(t *testing.T) {
+	tests := []struct {
+		symbol  string
+		want    Ticker
+		wantErr bool
+	}{
+		{"SOJ.ROS/MAY24", Ticker{Product: "SOJ", Harbour: "ROS", Month: time.May, Year: 2024}, false},
+		{"SOJ.MIN/DIC09", Ticker{Product: "SOJ", Harbour: "MIN", Month: time.December, Year: 2009}, false},
+		{"SOJ.ROS/XXX24", Ticker{}, true},
+		{"SOJ.ROS/may24", Ticker{}, true},
+		{"SOJ.ROS/MAY-1", Ticker{}, true},
+		{"SOJ.ROS/MAY+1", Ticker{}, true},
+		{"SOJ.ROS/MAY2", Ticker{}, true},
+		{"SOJ.ROS/MAY2024", Ticker{}, true},
+		{"SOJ.ROS/MAY2A", Ticker{}, true},
+		{"SOJ.ROS", Ticker{}, true},
+		{".ROS/MAY24", Ticker{}, true},
+		{"SOJ/MAY24", Ticker{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTicker(tt.symbol)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTicker(%q) error = %v, wantErr %v", tt.symbol, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTicker(%q) = %+v, want %+v", tt.symbol, got, tt.want)
+		}
+		if err == nil && got.String() != tt.symbol {
+			t.Errorf("ParseTicker(%q).String() = %q", tt.symbol, got.String())
+		}
+	}
+}
+
+func TestSymbolMap(t *testing.T) {
+	symbols := NewSymbolMap()
+	symbols.RegisterHarbour("MIX", "ROS")
+
+	std, err := symbols.StdFor("SOJ.MIN/MAY24")
+	if err != nil || std != "SOJ.ROS/MAY24" {
+		t.Errorf("StdFor(SOJ.MIN/MAY24) = %q, %v, want SOJ.ROS/MAY24", std, err)
+	}
+	//un mercado sin mini no se puede cubrir
+	if std, err := symbols.StdFor("SOJ.XYZ/MAY24"); err == nil {
+		t.Errorf("StdFor(SOJ.XYZ/MAY24) = %q, want an error", std)
+	}
+	if mini, err := symbols.MiniFor("SOJ.XYZ/MAY24"); err == nil {
+		t.Errorf("MiniFor(SOJ.XYZ/MAY24) = %q, want an error", mini)
+	}
+
+	//MIN y MIX cubren con ROS: siempre gana el mismo
+	for i := 0; i < 20; i++ {
+		mini, err := symbols.MiniFor("SOJ.ROS/MAY24")
+		if err != nil || mini != "SOJ.MIN/MAY24" {
+			t.Fatalf("MiniFor(SOJ.ROS/MAY24) = %q, %v, want SOJ.MIN/MAY24", mini, err)
+		}
+	}
+	symbols.Register("SOJ.MIX/JUL24", "SOJ.ROS/JUL24")
+	symbols.Register("SOJ.MIA/JUL24", "SOJ.ROS/JUL24")
+	for i := 0; i < 20; i++ {
+		mini, err := symbols.MiniFor("SOJ.ROS/JUL24")
+		if err != nil || mini != "SOJ.MIA/JUL24" {
+			t.Fatalf("MiniFor(SOJ.ROS/JUL24) = %q, %v, want SOJ.MIA/JUL24", mini, err)
+		}
+	}
+}
+
+func TestSymbolMapNextMonth(t *testing.T) {
+	tests := []struct {
+		symbol  string
+		want    string
+		wantErr bool
+	}{
+		{"SOJ.ROS/MAY24", "SOJ.ROS/JUN24", false},
+		{"SOJ.ROS/DIC24", "SOJ.ROS/ENE25", false},
+		{"SOJ.ROS/DIC99", "SOJ.ROS/ENE00", false},
+		{"SOJ.ROS/XXX24", "", true},
+	}
+	symbols := NewSymbolMap()
+	for _, tt := range tests {
+		got, err := symbols.NextMonth(tt.symbol)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NextMonth(%q) = %q, %v, want %q", tt.symbol, got, err, tt.want)
+		}
+	}
+}
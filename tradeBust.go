@@ -0,0 +1,124 @@
+package minis
+
+import (
+	"sync"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/position"
+	"github.com/deltafund/components-support/storage"
+)
+
+// ReversiblePosition es una posicion local que puede deshacer una ejecucion.
+type ReversiblePosition interface {
+	ReverseExecution(orderEvent order.OrderEvent) *position.PositionEvent
+}
+
+type SyntheticPositionListener interface {
+	OnSyntheticPositionChange(syntheticInstrument string, event position.PositionEvent)
+}
+
+type SecurityPositionListener interface {
+	OnSecurityPositionChange(security security.Security, event position.PositionEvent)
+}
+
+type syntheticEntry struct {
+	name      string
+	position  ReversiblePosition
+	listeners []SyntheticPositionListener
+}
+
+type securityEntry struct {
+	security  security.Security
+	position  ReversiblePosition
+	listeners []SecurityPositionListener
+}
+
+// TradeBustHandler recibe los trade cancel del mercado, revierte la ejecucion
+// en las posiciones locales y reenvia el PositionEvent corregido para que el
+// balancer y los market makers vuelvan a evaluar. Las correcciones llegan
+// como cancel del trade original mas un trade nuevo, asi que alcanza con
+// revertir. Solo se revierten los trades de accounts, una vez por ExecId.
+type TradeBustHandler struct {
+	rwMutex    sync.RWMutex
+	logger     *storage.Logger
+	accounts   map[string]bool
+	reversed   map[string]bool
+	synthetics []syntheticEntry
+	securities []securityEntry
+}
+
+func NewTradeBustHandler(accounts []string) *TradeBustHandler {
+	h := &TradeBustHandler{
+		logger:   storage.NewLogger("trade-bust"),
+		accounts: make(map[string]bool),
+		reversed: make(map[string]bool),
+	}
+	for _, account := range accounts {
+		h.accounts[account] = true
+	}
+	return h
+}
+
+func (h *TradeBustHandler) AddSynthetic(name string, pos ReversiblePosition, listeners ...SyntheticPositionListener) {
+	h.rwMutex.Lock()
+	h.synthetics = append(h.synthetics, syntheticEntry{name: name, position: pos, listeners: listeners})
+	h.rwMutex.Unlock()
+}
+
+func (h *TradeBustHandler) AddSecurity(sec security.Security, pos ReversiblePosition, listeners ...SecurityPositionListener) {
+	h.rwMutex.Lock()
+	h.securities = append(h.securities, securityEntry{security: sec, position: pos, listeners: listeners})
+	h.rwMutex.Unlock()
+}
+
+func (h *TradeBustHandler) OnTradeCancel(tradeCancel order.TradeCancel) {
+	h.logger.Printf("OnTradeCancel %+v", tradeCancel)
+
+	h.rwMutex.Lock()
+	defer h.rwMutex.Unlock()
+
+	if !h.accounts[tradeCancel.Order.Account] {
+		return
+	}
+	execId := tradeCancel.ExecutionReport.ExecId
+	if execId != "" {
+		if h.reversed[execId] {
+			h.logger.Printf("Trade %s already reversed", execId)
+			return
+		}
+		h.reversed[execId] = true
+	}
+
+	for _, entry := range h.synthetics {
+		event := entry.position.ReverseExecution(tradeCancel.OrderEvent)
+		if event == nil {
+			continue
+		}
+		h.logger.Printf("%s corregida %+v -> %+v", entry.name, event.OldPosition, event.NewPosition)
+		for _, listener := range entry.listeners {
+			listener.OnSyntheticPositionChange(entry.name, *event)
+		}
+	}
+
+	for _, entry := range h.securities {
+		event := entry.position.ReverseExecution(tradeCancel.OrderEvent)
+		if event == nil {
+			continue
+		}
+		h.logger.Printf("%s corregida %+v -> %+v", entry.security.Symbol, event.OldPosition, event.NewPosition)
+		for _, listener := range entry.listeners {
+			listener.OnSecurityPositionChange(entry.security, *event)
+		}
+	}
+}
+
+// exchange callbacks ///
+
+func (h *TradeBustHandler) OnOrderRegistered(orderRegistered order.OrderRegistered) {}
+func (h *TradeBustHandler) OnStartFinish(exchange security.Exchange)                {}
+func (h *TradeBustHandler) OnTradeFromAnotherAccount(tradeFromAnotherAccount order.TradeFromAnotherAccount) {
+}
+func (h *TradeBustHandler) OnDisconnect(exchange security.Exchange)                   {}
+func (h *TradeBustHandler) OnSecurityStatus(securityStatus marketdata.SecurityStatus) {}
@@ -0,0 +1,142 @@
+package minis
+
+import (
+	"testing"
+
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+	"github.com/deltafund/components-support/position"
+)
+
+type fakeSecurityListener struct {
+	events []position.PositionEvent
+}
+
+func (fl *fakeSecurityListener) OnSecurityPositionChange(security security.Security, event position.PositionEvent) {
+	fl.events = append(fl.events, event)
+}
+
+func testSpecs() *ContractSpecs {
+	specs := NewContractSpecs()
+	specs.Register(testStd, ContractSpec{TickSize: 0.5, Multiplier: 100})
+	specs.Register(testMini, ContractSpec{TickSize: 0.5, Multiplier: 10})
+	return specs
+}
+
+// la orden es de 5 contratos y el fill de 2: las posiciones cuentan el fill
+func testExecution(sec security.Security) order.OrderEvent {
+	return order.OrderEvent{
+		Order:           order.Order{Id: "order-1", Account: "account", Security: sec, Side: order.Side_SELL, Qty: 5, CumQty: 2, Px: 99},
+		ExecutionReport: order.ExecutionReport{Side: order.Side_SELL, Qty: 2, Px: 100, ExecId: "exec-1"},
+		Qty:             2,
+		Px:              100,
+	}
+}
+
+func TestTradeBustCorrectsSecurityPositions(t *testing.T) {
+	specs := testSpecs()
+	netFuture, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	miniTons := NewTonsPosition(testMini, position.Position{}, specs)
+	listener := &fakeSecurityListener{}
+	handler := NewTradeBustHandler([]string{"account"})
+	handler.AddSynthetic("net", netFuture)
+	handler.AddSecurity(testMini, miniTons, listener)
+
+	execution := testExecution(testMini)
+	netFuture.ConsumeExecution(execution, nil, nil, nil, nil)
+	miniTons.ConsumeExecution(execution, nil, nil, nil, nil)
+	if netFuture.Position().NetQty != -20 || miniTons.Position().NetQty != -20 {
+		t.Fatalf("positions after the fill = %v, %v, want -20 tons each", netFuture.Position().NetQty, miniTons.Position().NetQty)
+	}
+
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: execution})
+	if netFuture.Position().NetQty != 0 || miniTons.Position().NetQty != 0 {
+		t.Fatalf("positions after the bust = %v, %v, want 0", netFuture.Position().NetQty, miniTons.Position().NetQty)
+	}
+	if len(listener.events) != 1 || listener.events[0].NewPosition.NetQty != 0 || listener.events[0].OldPosition.NetQty != -20 {
+		t.Fatalf("security listener events = %+v, want the corrected position", listener.events)
+	}
+
+	//un trade de otro instrumento no toca las posiciones
+	foreign := testExecution(security.Security{Symbol: "TRI.ROS/MAY24"})
+	foreign.ExecutionReport.ExecId = "exec-2"
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: foreign})
+	if len(listener.events) != 1 {
+		t.Fatalf("security listener notified for a foreign trade: %+v", listener.events)
+	}
+}
+
+func TestTradeBustIgnoresSiblingAndDuplicateBusts(t *testing.T) {
+	specs := testSpecs()
+	stdTons := NewTonsPosition(testStd, position.Position{}, specs)
+	listener := &fakeSecurityListener{}
+	handler := NewTradeBustHandler([]string{"account"})
+	handler.AddSecurity(testStd, stdTons, listener)
+
+	own := testExecution(testStd)
+	stdTons.ConsumeExecution(own, nil, nil, nil, nil)
+	again := testExecution(testStd)
+	again.ExecutionReport.ExecId = "exec-2"
+	stdTons.ConsumeExecution(again, nil, nil, nil, nil)
+
+	sibling := testExecution(testStd)
+	sibling.Order.Account = "sibling"
+	sibling.ExecutionReport.ExecId = "exec-sibling"
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: sibling})
+	if stdTons.Position().NetQty != -400 || len(listener.events) != 0 {
+		t.Fatalf("sibling bust changed the position to %v", stdTons.Position().NetQty)
+	}
+
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: own})
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: own})
+	if stdTons.Position().NetQty != -200 || len(listener.events) != 1 {
+		t.Fatalf("position after a duplicate bust = %v, want -200 reversed once", stdTons.Position().NetQty)
+	}
+}
+
+func TestTradeBustRestoresPnL(t *testing.T) {
+	specs := testSpecs()
+	netFuture, err := NewNetFuturePosition("net", NetFutureLegs(testStd.Symbol, testMini.Symbol), specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pnl := NewPnLEngine(testStd, testMini, specs, newFakeClock())
+	netFuture.SetPnLEngine(pnl)
+	handler := NewTradeBustHandler([]string{"account"})
+	handler.AddSynthetic("net", netFuture)
+
+	fill := func(execId string, side order.Side, qty float64, px float64) order.OrderEvent {
+		event := legFill(testMini.Symbol, side, qty, px)
+		event.Order.Account = "account"
+		event.ExecutionReport.ExecId = execId
+		return event
+	}
+	closing := fill("exec-2", order.Side_SELL, 1, 110)
+	netFuture.ConsumeExecution(fill("exec-1", order.Side_BUY, 2, 100), nil, nil, nil, nil)
+	netFuture.ConsumeExecution(closing, nil, nil, nil, nil)
+	netFuture.ConsumeExecution(fill("exec-3", order.Side_BUY, 2, 94), nil, nil, nil, nil)
+	if combined := pnl.Snapshot().Combined; combined.NetQty != 30 || combined.AvgPx != 96 || combined.RealizedPnL != 100 {
+		t.Fatalf("pnl before the bust = %+v, want 30 tons @ 96 with 100 realized", combined)
+	}
+
+	sibling := closing
+	sibling.Order.Account = "sibling"
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: sibling})
+	if combined := pnl.Snapshot().Combined; combined.RealizedPnL != 100 {
+		t.Fatalf("sibling bust changed the pnl to %+v", combined)
+	}
+
+	//sin el trade anulado queda como si solo se hubieran hecho las dos compras
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: closing})
+	handler.OnTradeCancel(order.TradeCancel{OrderEvent: closing})
+	combined := pnl.Snapshot().Combined
+	if combined.NetQty != 40 || combined.AvgPx != 97 || combined.RealizedPnL != 0 {
+		t.Fatalf("pnl after the bust = %+v, want 40 tons @ 97 with nothing realized", combined)
+	}
+	if mini := pnl.Snapshot().Securities[testMini.Symbol]; mini.NetQty != 40 || mini.AvgPx != 97 || mini.RealizedPnL != 0 {
+		t.Fatalf("mini pnl after the bust = %+v, want 40 tons @ 97 with nothing realized", mini)
+	}
+}
@@ -0,0 +1,89 @@
+package minis
+
+import (
+	"sync"
+
+	"github.com/deltafund/api-fix/marketdata"
+)
+
+type TradingPhase int
+
+const (
+	PHASE_UNKNOWN TradingPhase = iota
+	PHASE_PRE_OPEN
+	PHASE_AUCTION
+	PHASE_CONTINUOUS
+	PHASE_HALTED
+	PHASE_CLOSED
+)
+
+func (p TradingPhase) String() string {
+	switch p {
+	case PHASE_PRE_OPEN:
+		return "pre-open"
+	case PHASE_AUCTION:
+		return "auction"
+	case PHASE_CONTINUOUS:
+		return "continuous"
+	case PHASE_HALTED:
+		return "halted"
+	case PHASE_CLOSED:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// PhaseFromStatus traduce el SecurityTradingStatus de FIX (tag 326).
+func PhaseFromStatus(securityStatus marketdata.SecurityStatus) TradingPhase {
+	switch int(securityStatus.Status) {
+	case 3, 17:
+		return PHASE_CONTINUOUS
+	case 2:
+		return PHASE_HALTED
+	case 21:
+		return PHASE_PRE_OPEN
+	case 22, 24, 25:
+		return PHASE_AUCTION
+	case 4, 18, 26:
+		return PHASE_CLOSED
+	}
+	return PHASE_UNKNOWN
+}
+
+// PhaseTracker guarda la fase de negociacion de cada instrumento. Mientras no
+// llegue ningun status el instrumento se considera operable.
+type PhaseTracker struct {
+	rwMutex sync.RWMutex
+	phases  map[string]TradingPhase
+}
+
+func NewPhaseTracker() *PhaseTracker {
+	return &PhaseTracker{
+		phases: make(map[string]TradingPhase),
+	}
+}
+
+// Update devuelve true si cambio la fase del instrumento.
+func (pt *PhaseTracker) Update(securityStatus marketdata.SecurityStatus) bool {
+	phase := PhaseFromStatus(securityStatus)
+	symbol := securityStatus.Security.Symbol
+
+	pt.rwMutex.Lock()
+	defer pt.rwMutex.Unlock()
+	old, ok := pt.phases[symbol]
+	pt.phases[symbol] = phase
+	return !ok || old != phase
+}
+
+func (pt *PhaseTracker) Phase(symbol string) TradingPhase {
+	pt.rwMutex.RLock()
+	defer pt.rwMutex.RUnlock()
+	return pt.phases[symbol]
+}
+
+func (pt *PhaseTracker) Tradable(symbol string) bool {
+	pt.rwMutex.RLock()
+	defer pt.rwMutex.RUnlock()
+	phase, ok := pt.phases[symbol]
+	return !ok || phase == PHASE_CONTINUOUS
+}
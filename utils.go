@@ -1,11 +1,5 @@
 package minis
 
-import (
-	"strings"
-
-	"github.com/deltafund/components-support/position"
-)
-
 type SpreadData struct {
 	SymbolPase    string
 	SymbolEarly   string
@@ -16,9 +10,14 @@ type SpreadData struct {
 	PriorityLate  int
 }
 
+// Deprecated: usar SymbolMap.StdFor, que parsea el ticker en vez de
+// reemplazar "MIN" en cualquier parte del simbolo.
 func MiniToStdName(ticker string) string {
-	ticker = strings.Replace(ticker, "MIN", "ROS", -1)
-	return ticker
+	stdTicker, err := NewSymbolMap().StdFor(ticker)
+	if err != nil {
+		return ticker
+	}
+	return stdTicker
 }
 
 //func (mm *MinisMarketMaker) balanceContractQty(security security.Security, event position.PositionEvent, tradedPx float64) {
@@ -42,19 +41,3 @@ func MiniToStdName(ticker string) string {
 // 	//volver a valores anteriores de mm y quantity (o no usar mm, sino otro componente)
 // 	mm = &marketMaker
 //}
-
-type NetFuturePosition struct {
-	netFutureSymbol string
-	signs           map[string]float64
-	products        map[string]string
-	position        position.Position
-	historical      position.Position
-}
-
-type PositionEvent struct {
-	Symbol                string
-	OldPosition           position.Position
-	NewPosition           position.Position
-	OldHistoricalPosition position.Position
-	NewHistoricalPosition position.Position
-}
@@ -0,0 +1,99 @@
+package minis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deltafund/components-support/storage"
+)
+
+// DEFAULT_ACK_TIMEOUT es cuanto se espera la respuesta del exchange antes de
+// pedir el estado de la orden.
+const DEFAULT_ACK_TIMEOUT = 10 * time.Second
+
+// Watched es una estrategia con ordenes que el watchdog puede revisar.
+type Watched interface {
+	CheckPendingAck(now time.Time, timeout time.Duration) (string, bool)
+}
+
+// Watchdog revisa cada interval las acciones pendientes de ack de todas las
+// estrategias registradas, loguea y avisa por alert cuando alguna supera
+// timeout. alert puede ser nil.
+type Watchdog struct {
+	mutex    sync.Mutex
+	clock    Clock
+	timeout  time.Duration
+	interval time.Duration
+	alert    func(string)
+	logger   *storage.Logger
+	watched  []Watched
+	timer    Timer
+	running  bool
+}
+
+func NewWatchdog(clock Clock, timeout time.Duration, interval time.Duration, alert func(string)) *Watchdog {
+	if clock == nil {
+		clock = NewSystemClock()
+	}
+
+	return &Watchdog{
+		clock:    clock,
+		timeout:  timeout,
+		interval: interval,
+		alert:    alert,
+		logger:   storage.NewLogger("watchdog"),
+	}
+}
+
+func (w *Watchdog) Register(watched ...Watched) {
+	w.mutex.Lock()
+	w.watched = append(w.watched, watched...)
+	w.mutex.Unlock()
+}
+
+func (w *Watchdog) Start() {
+	w.mutex.Lock()
+	if !w.running {
+		w.running = true
+		w.timer = w.clock.AfterFunc(w.interval, w.tick)
+	}
+	w.mutex.Unlock()
+}
+
+func (w *Watchdog) Stop() {
+	w.mutex.Lock()
+	w.running = false
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.mutex.Unlock()
+}
+
+// Check revisa una vez todas las estrategias.
+func (w *Watchdog) Check() {
+	w.mutex.Lock()
+	watched := make([]Watched, len(w.watched))
+	copy(watched, w.watched)
+	w.mutex.Unlock()
+
+	now := w.clock.Now()
+	for _, strategy := range watched {
+		if msg, stuck := strategy.CheckPendingAck(now, w.timeout); stuck {
+			w.logger.Printf("ALERT: %s", msg)
+			if w.alert != nil {
+				w.alert(msg)
+			}
+		}
+	}
+}
+
+func (w *Watchdog) tick() {
+	w.Check()
+
+	w.mutex.Lock()
+	if w.running {
+		w.timer = w.clock.AfterFunc(w.interval, w.tick)
+	}
+	w.mutex.Unlock()
+}
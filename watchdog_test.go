@@ -0,0 +1,83 @@
+package minis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deltafund/api-fix/marketdata"
+	"github.com/deltafund/api-fix/order"
+	"github.com/deltafund/api-fix/security"
+)
+
+type fakeWatched struct {
+	checks []time.Time
+	stuck  bool
+}
+
+func (fw *fakeWatched) CheckPendingAck(now time.Time, timeout time.Duration) (string, bool) {
+	fw.checks = append(fw.checks, now)
+	return "stuck", fw.stuck
+}
+
+func TestWatchdogTicksUntilStopped(t *testing.T) {
+	clock := newFakeClock()
+	alerts := []string{}
+	w := NewWatchdog(clock, 10*time.Second, 5*time.Second, func(msg string) { alerts = append(alerts, msg) })
+	quiet := &fakeWatched{}
+	stuck := &fakeWatched{stuck: true}
+	w.Register(quiet, stuck)
+
+	w.Start()
+	w.Start()
+	clock.Advance(12 * time.Second)
+	if len(quiet.checks) != 2 || len(stuck.checks) != 2 {
+		t.Fatalf("checks = %d, %d, want 2 each", len(quiet.checks), len(stuck.checks))
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("alerts = %q, want one per stuck check", alerts)
+	}
+
+	w.Stop()
+	clock.Advance(time.Minute)
+	if len(quiet.checks) != 2 || clock.Pending() != 0 {
+		t.Fatalf("watchdog kept checking after Stop: %d checks, %d timers", len(quiet.checks), clock.Pending())
+	}
+}
+
+func TestWatchdogKeepsStuckHedgeBlocked(t *testing.T) {
+	b, fb, clock := newTestBalancer(t)
+	b.OnStartFinish(security.Exchange_ROFEX)
+	b.OnBookUpdated(marketdata.BookUpdated{Security: testStd, Book: testBook(100, 101)})
+	tu := &fakeTraderUpdater{}
+	w := NewWatchdog(clock, 10*time.Second, 5*time.Second, tu.SendToast)
+	w.Register(b)
+	w.Start()
+	defer w.Stop()
+
+	b.OnSyntheticPositionChange("net", netQtyEvent(230))
+	if len(fb.places) != 1 {
+		t.Fatalf("places = %+v, want one hedge", fb.places)
+	}
+	stuckOrder := fb.lastOrder()
+
+	//sin ack se pide el estado con un cancel y no se manda otra cobertura
+	for i := 1; i <= 3; i++ {
+		clock.Advance(10 * time.Second)
+		if len(fb.cancels) != i || fb.cancels[i-1].Order.Id != stuckOrder.Id {
+			t.Fatalf("after %d timeouts cancels = %+v", i, fb.cancels)
+		}
+		if len(fb.places) != 1 || b.slot.State() != SLOT_PENDING_CANCEL {
+			t.Fatalf("slot released while the order may be live: places %d state %v", len(fb.places), b.slot.State())
+		}
+	}
+	if len(tu.toasts) != 3 || !strings.Contains(tu.toasts[2], CMD_RESET_STATE) {
+		t.Fatalf("toasts = %q, want an alert per timeout", tu.toasts)
+	}
+
+	//la respuesta del exchange libera el slot y se vuelve a cubrir
+	b.OnOrderCancelled(order.OrderCancelled{OrderEvent: order.OrderEvent{Order: stuckOrder}})
+	if len(fb.places) != 2 {
+		t.Fatalf("places = %d after the cancel, want a new hedge", len(fb.places))
+	}
+}